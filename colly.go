@@ -6,12 +6,17 @@ import (
 	"github.com/gocolly/colly/v2"
 )
 
-func NewCollector(ctx context.Context, userAgent string) *colly.Collector {
+// DefaultUserAgent is sent by the scraper and the native download backend.
+const DefaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36"
+
+// NewCollector returns a collector whose requests are aborted once ctx is canceled.
+// No new requests are started after stop is canceled.
+func NewCollector(ctx, stop context.Context, userAgent string) *colly.Collector {
 	co := colly.NewCollector()
 	co.WithTransport(NewContextTransport(ctx))
 	co.OnRequest(func(r *colly.Request) {
 		select {
-		case <-ctx.Done():
+		case <-stop.Done():
 			r.Abort()
 			return
 		default:
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/utils"
+	giturls "github.com/whilp/git-urls"
+)
+
+// DownloadConfig configures the download subcommand.
+type DownloadConfig struct {
+	Backend      string `koanf:"backend" description:"Download backend: native or yt-dlp"`
+	YouTubeDLDir string `koanf:"youtube.dl.dir" short:"y" description:"Path to yt-dlp directory"`
+	OutDir       string `koanf:"out.dir" short:"o" description:"Output directory"`
+	TempDir      string `koanf:"temp.dir" description:"Staging directory of running downloads, defaults to the staging directory of the cache directory"`
+
+	Reinitialize bool `koanf:"reinitialize" short:"i" description:"Re-initialize yt-dlp"`
+	DryRun       bool `koanf:"dry.run" short:"d" description:"Dry run: don't download, just print out URLs"`
+
+	RepoUrl string `koanf:"repo.url" short:"r" description:"URL to yt-dlp repository"`
+	Branch  string `koanf:"branch" short:"b" description:"Branch to use for yt-dlp"`
+
+	Language  string `koanf:"language" short:"l" description:"Comma separated list of languages to download"`
+	UserAgent string `koanf:"user.agent" description:"User agent to use for requests"`
+
+	MinRate string `koanf:"min.rate" description:"Minimum download rate"`
+
+	DirTemplate  string `koanf:"dir.template" description:"Go template of the directory relative to the output directory, / creates nested directories"`
+	FileTemplate string `koanf:"file.template" description:"Go template of the file name without extension"`
+
+	Nfo           bool `koanf:"nfo" description:"Write Kodi/Jellyfin .nfo files and thumbnails next to the episodes"`
+	EmbedMetadata bool `koanf:"embed.metadata" description:"Embed title, description, season, episode, air date and cover art into the episodes"`
+
+	Force  bool `koanf:"force" short:"f" description:"Download episodes again that have already been downloaded"`
+	Failed bool `koanf:"failed" description:"Only retry episodes whose previous download failed"`
+
+	Jobs  int    `koanf:"jobs" short:"j" description:"Number of episodes to download in parallel"`
+	Order string `koanf:"order" description:"Download order: asc (oldest first) or desc (newest first)"`
+
+	Progress string `koanf:"progress" description:"Progress display: auto, live (terminal), plain (periodic log lines) or none"`
+
+	StallTimeout time.Duration `koanf:"stall.timeout" description:"Kill and retry a download whose files and output did not grow for this duration, 0 disables the watchdog"`
+
+	Retries         int           `koanf:"retries" description:"Number of retries of a download that failed with a transient error"`
+	RetryBackoff    time.Duration `koanf:"retry.backoff" description:"Delay before the first retry of a download, doubled with every retry"`
+	RetryMaxBackoff time.Duration `koanf:"retry.max.backoff" description:"Maximum delay between two retries of a download"`
+}
+
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+
+	BackendNative = "native"
+	BackendYtDlp  = "yt-dlp"
+
+	ProgressAuto  = "auto"
+	ProgressLive  = "live"
+	ProgressPlain = "plain"
+	ProgressNone  = "none"
+)
+
+var rateRegex = regexp.MustCompile(`^\d+[KMG]$`)
+
+func (c *DownloadConfig) Validate() error {
+	switch c.Backend {
+	case BackendNative, BackendYtDlp:
+	default:
+		return fmt.Errorf("invalid backend: %q, must be one of %s or %s", c.Backend, BackendNative, BackendYtDlp)
+	}
+
+	// the native backend does not need yt-dlp
+	if c.Backend == BackendYtDlp {
+		err := c.initYtDlp()
+		if err != nil {
+			return err
+		}
+	}
+
+	foundOutDir, err := utils.ExistsDir(c.OutDir)
+	if err != nil {
+		return err
+	}
+
+	if !foundOutDir {
+		err := os.MkdirAll(c.OutDir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !rateRegex.MatchString(c.MinRate) {
+		return fmt.Errorf("invalid min rate: %q, must match %s", c.MinRate, rateRegex.String())
+	}
+
+	if c.DirTemplate == "" {
+		return fmt.Errorf("dir template must not be empty")
+	}
+
+	if c.FileTemplate == "" {
+		return fmt.Errorf("file template must not be empty")
+	}
+
+	if c.Jobs < 1 {
+		return fmt.Errorf("jobs must be greater than 0")
+	}
+
+	switch c.Order {
+	case OrderAsc, OrderDesc:
+	default:
+		return fmt.Errorf("invalid order: %q, must be one of %s or %s", c.Order, OrderAsc, OrderDesc)
+	}
+
+	switch c.Progress {
+	case ProgressAuto, ProgressLive, ProgressPlain, ProgressNone:
+	default:
+		return fmt.Errorf("invalid progress display: %q, must be one of %s, %s, %s or %s",
+			c.Progress, ProgressAuto, ProgressLive, ProgressPlain, ProgressNone)
+	}
+
+	if c.StallTimeout < 0 {
+		return fmt.Errorf("stall timeout must not be negative")
+	}
+
+	if c.Retries < 0 {
+		return fmt.Errorf("retries must not be negative")
+	}
+
+	if c.RetryBackoff <= 0 {
+		return fmt.Errorf("retry backoff must be greater than 0")
+	}
+
+	if c.RetryMaxBackoff < c.RetryBackoff {
+		return fmt.Errorf("retry max backoff must be greater than or equal to retry backoff")
+	}
+
+	return nil
+}
+
+// initYtDlp clones the yt-dlp repository unless it already exists.
+func (c *DownloadConfig) initYtDlp() error {
+	foundYtDlDir, err := utils.ExistsDir(c.YouTubeDLDir)
+	if err != nil {
+		return err
+	}
+
+	if foundYtDlDir && c.Reinitialize {
+		err := os.RemoveAll(c.YouTubeDLDir)
+		if err != nil {
+			return err
+		}
+		foundYtDlDir = false
+	}
+
+	_, err = giturls.Parse(c.RepoUrl)
+	if err != nil {
+		return fmt.Errorf("invalid git url: %w", err)
+	}
+
+	if !foundYtDlDir {
+		err := utils.GitCloneBranch(context.Background(), c.YouTubeDLDir, c.RepoUrl, c.Branch)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,7 +2,6 @@ package config
 
 import (
 	"fmt"
-	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -65,6 +64,8 @@ type Validatable interface {
 // Your passed struct must define . delimited koanf struct tags in order to match env/.env and flag values to your struct.
 // Additionally your struct may define a Validate() error method which is called at the end of parsing the config
 // Registers flags and returns a parser function that can be used as PreRunE.
+// Every subcommand may register its own config struct. The returned parser must be called
+// after cobra parsed the command line, e.g. from within PreRunE.
 func RegisterFlags[T any](config *T, persistent bool, app *cobra.Command, options ...ParseOption) func() error {
 
 	op := parseOption{
@@ -113,14 +114,19 @@ func RegisterFlags[T any](config *T, persistent bool, app *cobra.Command, option
 
 	defaultMap, _ := maps.Flatten(defaults.All(), nil, op.delimiter)
 	maxKeyLen := maxKeyLen(defaultMap)
-	padding := maxKeyLen + len(op.envPrefix) + 1
+	// a minimum padding keeps the environment variables of multiple config structs aligned
+	padding := max(maxKeyLen+len(op.envPrefix)+1, 20)
 	format := "\n  %-" + strconv.Itoa(padding) + "s   %s"
 	var sb strings.Builder
 	sb.Grow((padding + 6) * len(defaultMap) * 3)
 
 	// register flags for all known struct fields
 
-	if ct.NumField() > 0 {
+	// multiple config structs may be registered on the same command
+	// and share a single list of environment variables
+	if strings.Contains(app.Long, "Environment variables:") {
+		app.Long = strings.TrimSuffix(app.Long, "\n")
+	} else if ct.NumField() > 0 {
 		sb.WriteString("Environment variables:")
 	}
 
@@ -137,6 +143,19 @@ func RegisterFlags[T any](config *T, persistent bool, app *cobra.Command, option
 		short := sTag.Get(op.shortTag)
 		flag := sTag.Get(op.flagTag)
 
+		// key is now a flag name
+		flagName := strings.ReplaceAll(key, op.delimiter, "-")
+
+		// config structs registered on the same command share flags with the same key,
+		// the flag is parsed into every struct
+		if fs.Lookup(flagName) != nil {
+			continue
+		}
+
+		if len(short) == 1 && fs.ShorthandLookup(short) != nil {
+			short = ""
+		}
+
 		envName := koanfToEnv(key)
 		// key, description
 		sb.WriteString(fmt.Sprintf(format, envName, desc))
@@ -146,9 +165,6 @@ func RegisterFlags[T any](config *T, persistent bool, app *cobra.Command, option
 			continue
 		}
 
-		// key is now a flag name
-		flagName := strings.ReplaceAll(key, op.delimiter, "-")
-
 		if v != nil {
 			// default value if not empty
 			defaultVal := fmt.Sprintf("%v", v)
@@ -269,18 +285,9 @@ func RegisterFlags[T any](config *T, persistent bool, app *cobra.Command, option
 			return err
 		}
 
-		// disable unknonwn flags errors
-		before := fs.ParseErrorsWhitelist
-		fs.ParseErrorsWhitelist.UnknownFlags = true
-		defer func() {
-			fs.ParseErrorsWhitelist = before
-		}()
-
-		err = fs.Parse(os.Args)
-		if err != nil {
-			return fmt.Errorf("failed to parse config flags: %w", err)
-		}
-
+		// flags have already been parsed by cobra at this point.
+		// Persistent flags share their *pflag.Flag with every subcommand's
+		// merged flag set, so values set on a subcommand are visible here.
 		flagSet := koanf.New(op.delimiter)
 		err = flagSet.Load(
 			posflag.ProviderWithValue(
@@ -0,0 +1,16 @@
+package config
+
+import "fmt"
+
+// ListConfig configures the list subcommand.
+type ListConfig struct {
+	Season   int    `koanf:"season" short:"s" description:"Only list episodes of a season"`
+	Language string `koanf:"language" short:"l" description:"Comma separated list of languages to list, empty lists all languages"`
+}
+
+func (c *ListConfig) Validate() error {
+	if c.Season < 0 {
+		return fmt.Errorf("season must be greater than or equal to 0")
+	}
+	return nil
+}
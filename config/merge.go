@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// MergeConfig configures the merge subcommand.
+type MergeConfig struct {
+	OutDir string `koanf:"out.dir" short:"o" description:"Output directory"`
+
+	Language     string `koanf:"language" short:"l" description:"Comma separated list of at least two downloaded languages, the video is taken from the first one"`
+	DefaultAudio string `koanf:"default.audio" description:"Language of the default audio track, defaults to the first language"`
+
+	MaxDurationDiff time.Duration `koanf:"max.duration.diff" description:"Maximum duration difference between the languages of an episode"`
+
+	DirTemplate  string `koanf:"dir.template" description:"Go template of the directory relative to the output directory, / creates nested directories"`
+	FileTemplate string `koanf:"file.template" description:"Go template of the file name without extension, .Language contains all merged languages joined by +"`
+
+	Force  bool `koanf:"force" short:"f" description:"Merge episodes again that have already been merged"`
+	DryRun bool `koanf:"dry.run" short:"d" description:"Dry run: don't merge, just print out the files that would be merged"`
+}
+
+func (c *MergeConfig) Validate() error {
+	if c.MaxDurationDiff < 0 {
+		return fmt.Errorf("max duration diff must not be negative")
+	}
+
+	if c.DirTemplate == "" {
+		return fmt.Errorf("dir template must not be empty")
+	}
+
+	if c.FileTemplate == "" {
+		return fmt.Errorf("file template must not be empty")
+	}
+
+	return nil
+}
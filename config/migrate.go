@@ -0,0 +1,10 @@
+package config
+
+// MigrateConfig configures the db migrate subcommand.
+type MigrateConfig struct {
+	DryRun bool `koanf:"dry.run" short:"d" description:"Dry run: only print pending migrations"`
+}
+
+func (c *MigrateConfig) Validate() error {
+	return nil
+}
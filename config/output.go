@@ -0,0 +1,22 @@
+package config
+
+import "fmt"
+
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// OutputConfig selects the format of the standard output.
+type OutputConfig struct {
+	Output string `koanf:"output" description:"Output format: text or json (newline delimited events on stdout, text on stderr)"`
+}
+
+func (c *OutputConfig) Validate() error {
+	switch c.Output {
+	case OutputText, OutputJSON:
+		return nil
+	default:
+		return fmt.Errorf("invalid output format: %q, must be one of %s or %s", c.Output, OutputText, OutputJSON)
+	}
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ScrapeConfig configures the scrape subcommand.
+type ScrapeConfig struct {
+	UserAgent string `koanf:"user.agent" description:"User agent to use for requests"`
+	Language  string `koanf:"language" short:"l" description:"Comma separated list of languages to scrape"`
+	Discovery string `koanf:"discovery" description:"Episode discovery: index (season listing pages, falls back to the sitemap), sitemap or links (follows the links between episode pages)"`
+	Repair    bool   `koanf:"repair" description:"Compare the catalog with the episodes listed by the site and re-crawl only missing and failed episode pages"`
+
+	Parallelism       int `koanf:"parallelism" description:"Maximum number of concurrent scrape requests"`
+	DomainParallelism int `koanf:"domain.parallelism" description:"Maximum number of concurrent scrape requests per domain"`
+
+	ScrapeDelay     time.Duration `koanf:"scrape.delay" description:"Minimum delay between the start of two requests to the same domain"`
+	ScrapeJitter    time.Duration `koanf:"scrape.jitter" description:"Maximum random delay that is added to the scrape delay"`
+	ThrottleRetries int           `koanf:"throttle.retries" description:"Number of times a request is retried after the site answered with 429 or 503, honoring its Retry-After header"`
+	Robots          bool          `koanf:"robots" description:"Obey the robots.txt of the sites"`
+
+	PageRetries         int           `koanf:"page.retries" description:"Number of retries of a page that failed with a network error, a server error other than 503 or an empty response"`
+	PageRetryBackoff    time.Duration `koanf:"page.retry.backoff" description:"Delay before the first retry of a page, doubled with every retry"`
+	PageRetryMaxBackoff time.Duration `koanf:"page.retry.max.backoff" description:"Maximum delay between two retries of a page"`
+}
+
+const (
+	DiscoveryIndex   = "index"
+	DiscoverySitemap = "sitemap"
+	DiscoveryLinks   = "links"
+)
+
+func (c *ScrapeConfig) Validate() error {
+	switch c.Discovery {
+	case DiscoveryIndex, DiscoverySitemap, DiscoveryLinks:
+	default:
+		return fmt.Errorf("invalid discovery: %q, must be one of %s, %s or %s", c.Discovery, DiscoveryIndex, DiscoverySitemap, DiscoveryLinks)
+	}
+
+	if c.Parallelism < 1 {
+		return fmt.Errorf("parallelism must be greater than 0")
+	}
+
+	if c.DomainParallelism < 1 {
+		return fmt.Errorf("domain parallelism must be greater than 0")
+	}
+
+	if c.ScrapeDelay < 0 {
+		return fmt.Errorf("scrape delay must be greater than or equal to 0")
+	}
+
+	if c.ScrapeJitter < 0 {
+		return fmt.Errorf("scrape jitter must be greater than or equal to 0")
+	}
+
+	if c.ThrottleRetries < 0 {
+		return fmt.Errorf("throttle retries must be greater than or equal to 0")
+	}
+
+	if c.PageRetries < 0 {
+		return fmt.Errorf("page retries must be greater than or equal to 0")
+	}
+
+	if c.PageRetryBackoff <= 0 {
+		return fmt.Errorf("page retry backoff must be greater than 0")
+	}
+
+	if c.PageRetryMaxBackoff < c.PageRetryBackoff {
+		return fmt.Errorf("page retry max backoff must be greater than or equal to page retry backoff")
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package config
+
+import "fmt"
+
+// SelectionConfig selects the episodes a subcommand operates on.
+type SelectionConfig struct {
+	All     bool `koanf:"all" short:"a" description:"Select all episodes"`
+	Season  int  `koanf:"season" short:"s" description:"Select all episodes of a season"`
+	Episode int  `koanf:"episode" short:"e" description:"Select a specific episode"`
+}
+
+func (c *SelectionConfig) Validate() error {
+	if c.All && (c.Season != 0 || c.Episode != 0) {
+		return fmt.Errorf("cannot use --all and --season or --episode at the same time")
+	}
+
+	if !c.All && c.Season == 0 && c.Episode == 0 {
+		return fmt.Errorf("must specify either --all or --season or --episode or --season and --episode")
+	}
+
+	if c.Season < 0 {
+		return fmt.Errorf("season must be greater than or equal to 0")
+	}
+
+	if c.Season == 0 && c.Episode != 0 {
+		return fmt.Errorf("--episode requires --season")
+	}
+
+	if c.Episode < 0 {
+		return fmt.Errorf("episode must be greater than or equal to 0")
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// WatchConfig configures the watch subcommand.
+type WatchConfig struct {
+	Interval time.Duration `koanf:"interval" description:"Interval between two checks for new episodes, ignored in case a cron expression is set"`
+	Cron     string        `koanf:"cron" description:"Cron expression (minute hour day-of-month month day-of-week) of the checks for new episodes"`
+
+	Backoff    time.Duration `koanf:"backoff" description:"Initial delay before retrying a failed check, doubled on every consecutive failure"`
+	MaxBackoff time.Duration `koanf:"max.backoff" description:"Maximum delay before retrying a failed check"`
+}
+
+func (c *WatchConfig) Validate() error {
+	if c.Cron == "" && c.Interval < time.Minute {
+		return fmt.Errorf("interval must be at least one minute")
+	}
+
+	if c.Backoff <= 0 {
+		return fmt.Errorf("backoff must be greater than 0")
+	}
+
+	if c.MaxBackoff < c.Backoff {
+		return fmt.Errorf("max backoff must be greater than or equal to backoff")
+	}
+
+	return nil
+}
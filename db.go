@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jxsl13/southpark-downloader/config"
+	"github.com/spf13/cobra"
+)
+
+func NewDBCmd(root *rootContext) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "manage the local catalog database",
+		Args:  cobra.ExactArgs(0),
+	}
+
+	cmd.AddCommand(NewMigrateCmd(root))
+	return cmd
+}
+
+func NewMigrateCmd(root *rootContext) *cobra.Command {
+	migrateContext := &migrateContext{rootContext: root}
+
+	cmd := &cobra.Command{
+		Use:      "migrate",
+		Short:    "apply pending schema migrations",
+		Args:     cobra.ExactArgs(0),
+		RunE:     migrateContext.RunE,
+		PostRunE: migrateContext.PostRunE,
+	}
+
+	// register flags but defer parsing and validation of the final values
+	cmd.PreRunE = migrateContext.PreRunE(cmd)
+	return cmd
+}
+
+type migrateContext struct {
+	*rootContext
+	Config *config.MigrateConfig
+}
+
+func (c *migrateContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args []string) error {
+	c.Config = &config.MigrateConfig{}
+
+	runParser := config.RegisterFlags(c.Config, false, cmd)
+
+	return func(cmd *cobra.Command, args []string) error {
+		err := runParser()
+		if err != nil {
+			return err
+		}
+
+		err = c.parseConfig()
+		if err != nil {
+			return err
+		}
+
+		// do not migrate implicitly
+		return c.OpenDB()
+	}
+}
+
+func (c *migrateContext) RunE(cmd *cobra.Command, args []string) error {
+	version, err := c.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	applied, err := c.Migrate(c.Config.DryRun)
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		fmt.Printf("Database is up to date at version %d\n", version)
+		return nil
+	}
+
+	action := "Applied"
+	if c.Config.DryRun {
+		action = "Pending"
+	}
+
+	for _, m := range applied {
+		fmt.Printf("%s: %d %s\n", action, m.Version, m.Description)
+	}
+	return nil
+}
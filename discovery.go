@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sync"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/jxsl13/southpark-downloader/config"
+)
+
+const sitemapPath = "/sitemap.xml"
+
+var (
+	// /staffeln/south-park/yjy8n9/staffel-1
+	// /seasons/south-park/yjy8n9/season-1
+	seasonUrlRegex = regexp.MustCompile(`/[a-z]+/south-park/[0-9a-z]+/[a-z]+-[0-9]+$`)
+
+	ErrNoEpisodes = errors.New("no episode pages found")
+)
+
+// sitemap is either a sitemap index or a url set.
+type sitemap struct {
+	Sitemaps []string `xml:"sitemap>loc"`
+	Urls     []string `xml:"url>loc"`
+}
+
+// urlSet collects unique urls in the order they were added, it is safe for concurrent use.
+type urlSet struct {
+	mu   sync.Mutex
+	seen map[string]bool
+	urls []string
+}
+
+func (s *urlSet) Add(u string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	if s.seen[u] {
+		return
+	}
+	s.seen[u] = true
+	s.urls = append(s.urls, u)
+}
+
+// pageUrl returns the absolute url of a link without query and fragment.
+func pageUrl(base *url.URL, link string) (*url.URL, bool) {
+	u, err := base.Parse(link)
+	if err != nil {
+		return nil, false
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u, true
+}
+
+// crawlIndex discovers all episode pages of the locale up front and fetches
+// only the pages the catalog does not know yet and the pages that failed before.
+func (c *scrapeContext) crawlIndex(l Locale, w *catalogWriter) error {
+	siteUrl, _, err := GetIndex(c.Ctx, c.transport, l.IndexUrl)
+	if err != nil {
+		return err
+	}
+
+	urls, err := c.discover(l, siteUrl, w)
+	if err != nil {
+		return err
+	}
+
+	var pending urlSet
+	for _, u := range urls {
+		visited, err := c.Visited(u)
+		if err != nil {
+			return err
+		}
+		if !visited {
+			pending.Add(u)
+		}
+	}
+	unknown := len(pending.urls)
+
+	failed, err := c.FailedUrls(l.Language)
+	if err != nil {
+		return err
+	}
+	for _, u := range failed {
+		pending.Add(u)
+	}
+	slog.Info("discovered episode pages", "language", l.Language, "pages", len(urls), "unknown", unknown, "failed", len(failed))
+
+	co, err := c.newCollector(siteUrl, l, w)
+	if err != nil {
+		return err
+	}
+	c.parseEpisodes(co, l, w)
+
+	for _, u := range pending.urls {
+		if c.Stop.Err() != nil {
+			break
+		}
+		c.visitPage(co, l, w, u)
+	}
+	co.Wait()
+	return nil
+}
+
+// visitPage enqueues an episode page, requests that cannot be started are recorded as failed.
+func (c *scrapeContext) visitPage(co *colly.Collector, l Locale, w *catalogWriter, pageUrl string) {
+	err := visit(co, pageUrl, pageEpisode)
+	if err == nil || errors.Is(err, colly.ErrAlreadyVisited) || c.Ctx.Err() != nil {
+		return
+	}
+
+	slog.Warn("failed to fetch episode page", "url", pageUrl, "error", err)
+	w.RequestFailed(l.Language, pageUrl, err)
+}
+
+// discover returns the urls of all episode pages of the locale.
+// The links discovery has no listing of all episodes and uses the season index instead.
+func (c *scrapeContext) discover(l Locale, siteUrl string, w *catalogWriter) ([]string, error) {
+	if c.Config.Discovery != config.DiscoverySitemap {
+		urls, err := c.discoverSeasons(l, siteUrl, w)
+		if c.Stop.Err() != nil || (err == nil && len(urls) > 0) {
+			return urls, err
+		}
+		if err == nil {
+			err = ErrNoEpisodes
+		}
+		slog.Warn("season index discovery failed, falling back to the sitemap", "language", l.Language, "error", err)
+	}
+
+	urls, err := c.discoverSitemap(l, siteUrl, w)
+	if err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%w in %s", ErrNoEpisodes, sitemapPath)
+	}
+	return urls, nil
+}
+
+// discoverSeasons collects the episode links of all season listing pages.
+func (c *scrapeContext) discoverSeasons(l Locale, siteUrl string, w *catalogWriter) ([]string, error) {
+	seasonsUrl, err := SiteUrl(siteUrl, l.SeasonsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	co, err := c.newCollector(siteUrl, l, w)
+	if err != nil {
+		return nil, err
+	}
+
+	var found urlSet
+	co.OnHTML("a[href]", func(e *colly.HTMLElement) {
+		u, ok := pageUrl(e.Request.URL, e.Attr("href"))
+		if !ok {
+			return
+		}
+
+		switch {
+		case episodeUrlRegex.MatchString(u.Path):
+			found.Add(u.String())
+		case seasonUrlRegex.MatchString(u.Path):
+			_ = visit(co, u.String(), pageListing)
+		}
+	})
+
+	err = visit(co, seasonsUrl, pageListing)
+	if err != nil {
+		return nil, err
+	}
+	co.Wait()
+	return found.urls, nil
+}
+
+// discoverSitemap collects the episode urls of the sitemap and the sitemaps it references.
+func (c *scrapeContext) discoverSitemap(l Locale, siteUrl string, w *catalogWriter) ([]string, error) {
+	sitemapUrl, err := SiteUrl(siteUrl, sitemapPath)
+	if err != nil {
+		return nil, err
+	}
+
+	co, err := c.newCollector(siteUrl, l, w)
+	if err != nil {
+		return nil, err
+	}
+
+	var found urlSet
+	co.OnResponse(func(r *colly.Response) {
+		var sm sitemap
+		err := xml.Unmarshal(r.Body, &sm)
+		if err != nil {
+			slog.Warn("failed to parse sitemap", "url", r.Request.URL.String(), "error", err)
+			return
+		}
+
+		for _, loc := range sm.Sitemaps {
+			u, ok := pageUrl(r.Request.URL, loc)
+			if ok {
+				_ = visit(co, u.String(), pageListing)
+			}
+		}
+
+		for _, loc := range sm.Urls {
+			u, ok := pageUrl(r.Request.URL, loc)
+			if ok && episodeUrlRegex.MatchString(u.Path) {
+				found.Add(u.String())
+			}
+		}
+	})
+
+	err = visit(co, sitemapUrl, pageListing)
+	if err != nil {
+		return nil, err
+	}
+	co.Wait()
+	return found.urls, nil
+}
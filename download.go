@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/config"
+	"github.com/jxsl13/southpark-downloader/utils"
+	"github.com/spf13/cobra"
+)
+
+func NewDownloadCmd(root *rootContext) *cobra.Command {
+	downloadContext := &downloadContext{rootContext: root}
+
+	cmd := &cobra.Command{
+		Use:      "download",
+		Short:    "download episodes from the local catalog",
+		Args:     cobra.ExactArgs(0),
+		RunE:     downloadContext.RunE,
+		PostRunE: downloadContext.PostRunE,
+	}
+
+	// register flags but defer parsing and validation of the final values
+	cmd.PreRunE = downloadContext.PreRunE(cmd)
+	return cmd
+}
+
+type downloadContext struct {
+	*rootContext
+	Config     *config.DownloadConfig
+	Selection  *config.SelectionConfig
+	Output     *config.OutputConfig
+	Paths      *PathTemplate
+	Languages  []string
+	Downloader Downloader
+
+	// shared by all requests to the catalog hosts
+	transport http.RoundTripper
+	// progress of the running DownloadVideos call
+	progress *ProgressDisplay
+}
+
+func (c *downloadContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args []string) error {
+	c.Selection = &config.SelectionConfig{}
+
+	runSelectionParser := config.RegisterFlags(c.Selection, false, cmd)
+	runParser := c.RegisterFlags(cmd)
+
+	return func(cmd *cobra.Command, args []string) error {
+		err := runSelectionParser()
+		if err != nil {
+			return err
+		}
+
+		err = runParser()
+		if err != nil {
+			return err
+		}
+
+		err = c.Init()
+		if err != nil {
+			return err
+		}
+
+		return c.InitStaging()
+	}
+}
+
+// RegisterFlags registers the download flags on cmd, which allows other
+// subcommands to download episodes as well.
+func (c *downloadContext) RegisterFlags(cmd *cobra.Command) func() error {
+	c.Config = &config.DownloadConfig{
+		Backend:         config.BackendYtDlp,
+		Reinitialize:    false,
+		YouTubeDLDir:    "./yt-dlp",
+		OutDir:          "./downloads",
+		RepoUrl:         "https://github.com/yt-dlp/yt-dlp.git",
+		Branch:          "2023.03.04",
+		MinRate:         "1M",
+		Language:        "en",
+		UserAgent:       DefaultUserAgent,
+		Jobs:            max(1, runtime.NumCPU()/2),
+		Order:           config.OrderAsc,
+		Progress:        config.ProgressAuto,
+		StallTimeout:    2 * time.Minute,
+		Retries:         3,
+		RetryBackoff:    10 * time.Second,
+		RetryMaxBackoff: 5 * time.Minute,
+		DirTemplate:     DefaultDirTemplate,
+		FileTemplate:    DefaultFileTemplate,
+		Nfo:             true,
+		EmbedMetadata:   true,
+	}
+
+	c.Output = &config.OutputConfig{
+		Output: config.OutputText,
+	}
+
+	runParser := config.RegisterFlags(c.Config, false, cmd)
+	runOutputParser := config.RegisterFlags(c.Output, false, cmd)
+
+	return func() error {
+
+		err := runParser()
+		if err != nil {
+			return err
+		}
+
+		err = runOutputParser()
+		if err != nil {
+			return err
+		}
+		c.InitEvents(c.Output)
+
+		c.Paths, err = NewPathTemplate(c.Config.DirTemplate, c.Config.FileTemplate)
+		if err != nil {
+			return err
+		}
+
+		c.Languages, err = ParseLanguages(c.Config.Language)
+		if err != nil {
+			return err
+		}
+
+		if len(c.Languages) > 1 && !c.Paths.UsesLanguage() {
+			return fmt.Errorf("the dir or file template must contain {{.Language}} in order to download multiple languages")
+		}
+
+		if !utils.IsApplicationAvailable(c.Ctx, "ffmpeg") {
+			return fmt.Errorf("%w: ffmpeg", utils.ErrApplicationNotFound)
+		}
+
+		c.Downloader, err = NewDownloader(c.Config)
+		if err != nil {
+			return err
+		}
+
+		c.transport = NewPoliteTransport(http.DefaultTransport, defaultScrapeDelay, defaultScrapeJitter, defaultThrottleRetries)
+		return nil
+	}
+}
+
+func (c *downloadContext) RunE(cmd *cobra.Command, args []string) error {
+	err := c.Download(c.Selection.Season, c.Selection.Episode)
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%w: run the scrape command first", err)
+	}
+	return err
+}
+
+// ErrSkipped is returned for episodes that do not need to be downloaded.
+var ErrSkipped = errors.New("skipped")
+
+// jobResult is the outcome of a single download job.
+type jobResult struct {
+	Video    Video
+	Err      error
+	Duration time.Duration
+}
+
+func (c *downloadContext) Download(season, episode int) error {
+	// downloads of killed runs are still marked as running
+	reset, err := c.ResetRunning()
+	if err != nil {
+		return err
+	}
+	if reset > 0 {
+		slog.Info("reset interrupted downloads", "count", reset)
+	}
+
+	videos, err := c.Videos(c.Languages, season, episode)
+	if err != nil {
+		return err
+	}
+
+	if c.Config.Failed {
+		videos, err = c.FailedVideos(videos)
+		if err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	results := c.DownloadVideos(videos)
+	return c.summarize(results, time.Since(start))
+}
+
+// DownloadVideos downloads the videos in the configured order with a pool of workers.
+// The results are in the same order as the sorted videos.
+func (c *downloadContext) DownloadVideos(videos []Video) []jobResult {
+	SortVideos(videos, c.Config.Order)
+
+	c.progress = NewProgressDisplay(c.Config.Progress, len(videos), c.TextOutput())
+	// log records are printed above the live progress lines
+	restore := c.RedirectLogs(c.progress.Output(os.Stderr))
+	c.progress.Start()
+	defer func() {
+		c.progress.Stop()
+		restore()
+	}()
+
+	var (
+		jobs    = min(c.Config.Jobs, len(videos))
+		queue   = make(chan int)
+		results = make([]jobResult, len(videos))
+		wg      sync.WaitGroup
+	)
+
+	// every result is canceled until a worker picks up its job
+	for idx, v := range videos {
+		results[idx] = jobResult{Video: v, Err: context.Canceled}
+		c.Events.EmitVideo(EventDownloadQueued, v, Event{})
+	}
+
+	wg.Add(jobs)
+	for i := 0; i < jobs; i++ {
+		go func() {
+			defer wg.Done()
+			for idx := range queue {
+				results[idx] = c.downloadJob(videos[idx])
+			}
+		}()
+	}
+
+	// running downloads finish after the first interrupt, pending ones are canceled
+enqueue:
+	for idx := range videos {
+		if c.Stop.Err() != nil {
+			break
+		}
+
+		select {
+		case <-c.Stop.Done():
+			break enqueue
+		case queue <- idx:
+		}
+	}
+	close(queue)
+
+	wg.Wait()
+
+	return results
+}
+
+func (c *downloadContext) downloadJob(v Video) jobResult {
+	ctx, cancel := context.WithCancel(c.Ctx)
+	defer cancel()
+
+	start := time.Now()
+	err := c.DownloadVideo(ctx, v)
+	switch {
+	case err == nil, errors.Is(err, ErrSkipped):
+	case errors.Is(err, context.Canceled):
+		c.Events.EmitVideo(EventDownloadCanceled, v, Event{})
+	default:
+		reason := FailureReason(err)
+		c.Events.EmitVideo(EventDownloadFailed, v, Event{
+			Reason:   string(reason),
+			Error:    err.Error(),
+			Duration: time.Since(start).Seconds(),
+		})
+		slog.Error("failed to download video", "episode", v.Label(), "reason", reason, "error", err)
+		err = fmt.Errorf("%s: %w", v.Label(), err)
+	}
+	c.progress.Finish(v.Label(), err)
+
+	return jobResult{
+		Video:    v,
+		Err:      err,
+		Duration: time.Since(start),
+	}
+}
+
+func (c *rootContext) summarize(results []jobResult, dur time.Duration) error {
+	var (
+		downloaded int
+		skipped    int
+		canceled   int
+		errList    = make([]error, 0, len(results))
+	)
+
+	for _, r := range results {
+		switch {
+		case r.Err == nil:
+			downloaded++
+		case errors.Is(r.Err, ErrSkipped):
+			skipped++
+		case errors.Is(r.Err, context.Canceled):
+			canceled++
+		default:
+			errList = append(errList, r.Err)
+		}
+	}
+
+	slog.Info("downloaded videos",
+		"downloaded", downloaded,
+		"total", len(results),
+		"duration", dur.Round(time.Millisecond),
+		"skipped", skipped,
+		"failed", len(errList),
+		"canceled", canceled,
+	)
+
+	c.Events.Emit(Event{
+		Type: EventRunSummary,
+		Summary: &DownloadSummary{
+			Command:    "download",
+			Total:      len(results),
+			Downloaded: downloaded,
+			Skipped:    skipped,
+			Failed:     len(errList),
+			Canceled:   canceled,
+			Duration:   dur.Seconds(),
+		},
+	})
+
+	return errors.Join(errList...)
+}
+
+// SortVideos sorts videos by season and episode in the given order.
+func SortVideos(videos []Video, order string) {
+	sort.SliceStable(videos, func(i, j int) bool {
+		a, b := videos[i], videos[j]
+		if order == config.OrderDesc {
+			a, b = b, a
+		}
+		if a.Season != b.Season {
+			return a.Season < b.Season
+		}
+		return a.Episode < b.Episode
+	})
+}
+
+// FailedVideos returns the subset of videos whose last download attempt failed.
+func (c *downloadContext) FailedVideos(videos []Video) ([]Video, error) {
+	failed := make([]Video, 0, len(videos))
+	for _, v := range videos {
+		state, err := c.DownloadState(v.Language, v.Season, v.Episode)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+
+		if state.Status == StatusFailed {
+			failed = append(failed, v)
+		}
+	}
+	return failed, nil
+}
+
+// Downloaded returns the path of the video file in case the episode was
+// downloaded successfully and the downloaded file still exists.
+func (c *rootContext) Downloaded(v Video) (path string, downloaded bool, err error) {
+	state, err := c.DownloadState(v.Language, v.Season, v.Episode)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	if state.Status != StatusDone {
+		return "", false, nil
+	}
+
+	found, err := utils.ExistsFile(state.Path)
+	if err != nil || !found {
+		return "", false, err
+	}
+	return state.Path, true, nil
+}
+
+func (c *downloadContext) DownloadVideo(ctx context.Context, v Video) (err error) {
+	if !c.Config.Force {
+		path, downloaded, err := c.Downloaded(v)
+		if err != nil {
+			return err
+		}
+
+		if downloaded {
+			slog.Info("skipping downloaded video", "episode", v.Label(), "path", path)
+			c.Events.EmitVideo(EventDownloadSkipped, v, Event{
+				Path:   path,
+				Reason: "already downloaded",
+			})
+			// add missing metadata of previous downloads
+			c.writeSidecars(ctx, v, path, filepath.Dir(path))
+			return ErrSkipped
+		}
+	}
+
+	dir, err := c.Paths.Dir(v)
+	if err != nil {
+		return err
+	}
+
+	fileName, err := c.Paths.FileName(v)
+	if err != nil {
+		return err
+	}
+
+	if c.Config.DryRun {
+		slog.Info("would download video", "episode", v.Label(), "url", v.Url)
+		c.Events.EmitVideo(EventDownloadSkipped, v, Event{
+			Reason: "dry run",
+		})
+		return ErrSkipped
+	}
+
+	outDir := filepath.Join(c.Config.OutDir, filepath.FromSlash(dir))
+	err = os.MkdirAll(outDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	err = c.MarkRunning(v)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, c.MarkError(v, err))
+		}
+	}()
+
+	c.Events.EmitVideo(EventDownloadStarted, v, Event{})
+
+	// output of the child processes
+	log, err := c.EpisodeLog(v)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	staging := c.StagingDir(v)
+	err = os.MkdirAll(staging, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	// limits the number of progress events
+	var lastEvent time.Time
+	result, err := c.download(ctx, DownloadRequest{
+		Video:    v,
+		Dir:      staging,
+		FileName: fileName,
+		Log:      log,
+		Progress: func(p Progress) {
+			c.progress.Update(v.Label(), p)
+			if time.Since(lastEvent) >= time.Second || p.Percent >= 100 {
+				lastEvent = time.Now()
+				c.Events.EmitVideo(EventDownloadProgress, v, Event{
+					Progress: newProgressEvent(p),
+				})
+			}
+		},
+	})
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			// aborted downloads are not resumed
+			_ = os.RemoveAll(staging)
+		}
+		return err
+	}
+
+	// metadata is added to the staged file, the cover art of the embedded metadata
+	// reuses the thumbnail
+	c.writeSidecars(ctx, v, result.Path, outDir)
+	c.embedMetadata(ctx, v, result.Path)
+
+	err = verifyDownload(ctx, result.Path)
+	if err != nil {
+		// an invalid file must not be resumed
+		_ = os.RemoveAll(staging)
+		return err
+	}
+
+	// embedding metadata changes the file
+	sum, size, err := utils.FileSHA256(result.Path)
+	if err != nil {
+		return err
+	}
+
+	// media servers only ever see complete files with their metadata
+	result.Path, err = moveDownload(result.Path, outDir)
+	if err != nil {
+		return fmt.Errorf("failed to move download into the output directory: %w", err)
+	}
+
+	err = os.RemoveAll(staging)
+	if err != nil {
+		slog.Warn("failed to remove staging directory", "path", staging, "error", err)
+	}
+
+	err = c.MarkDone(v, result.Path, size, sum)
+	if err != nil {
+		return err
+	}
+
+	c.Events.EmitVideo(EventDownloadFinished, v, Event{
+		Path:     result.Path,
+		Size:     size,
+		Backend:  result.Backend,
+		Duration: result.Duration.Seconds(),
+	})
+	return nil
+}
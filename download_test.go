@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/config"
+	"github.com/jxsl13/southpark-downloader/hls"
+)
+
+// downloaderFunc allows to decide the outcome of a download per request.
+type downloaderFunc func(ctx context.Context, req DownloadRequest) (DownloadResult, error)
+
+func (f downloaderFunc) Download(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+	return f(ctx, req)
+}
+
+// newTestDownloadContext returns a download context with a fresh catalog database
+// and output directories in temporary directories.
+func newTestDownloadContext(t *testing.T, d Downloader) *downloadContext {
+	t.Helper()
+
+	// verifyDownload probes files with ffprobe in case it is installed, fake downloads are no videos
+	t.Setenv("PATH", t.TempDir())
+
+	dir := t.TempDir()
+	root := &rootContext{
+		Ctx:    context.Background(),
+		Stop:   context.Background(),
+		Config: &config.Config{ConfigDir: dir},
+	}
+
+	err := root.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = root.CloseDB() })
+
+	paths, err := NewPathTemplate(DefaultDirTemplate, DefaultFileTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &downloadContext{
+		rootContext: root,
+		Config: &config.DownloadConfig{
+			OutDir:          filepath.Join(dir, "downloads"),
+			TempDir:         filepath.Join(dir, "staging"),
+			Jobs:            2,
+			Order:           config.OrderAsc,
+			Progress:        config.ProgressNone,
+			Retries:         2,
+			RetryBackoff:    time.Millisecond,
+			RetryMaxBackoff: 4 * time.Millisecond,
+		},
+		Paths:      paths,
+		Languages:  []string{"en"},
+		Downloader: d,
+		transport:  http.DefaultTransport,
+		// replaced by DownloadVideos, DownloadVideo may be called directly
+		progress: NewProgressDisplay(config.ProgressNone, 0, io.Discard),
+	}
+}
+
+func testVideos(n int) []Video {
+	videos := make([]Video, 0, n)
+	for idx := n; idx > 0; idx-- {
+		videos = append(videos, Video{
+			Language: "en",
+			Title:    fmt.Sprintf("Episode %d", idx),
+			Season:   1,
+			Episode:  idx,
+			Url:      fmt.Sprintf("https://example.com/episodes/%d", idx),
+		})
+	}
+	return videos
+}
+
+func TestDownloadVideosWorkerPool(t *testing.T) {
+	const videos = 8
+
+	var (
+		mu      sync.Mutex
+		running int
+		peak    int
+	)
+	fake := &fakeDownloader{Data: []byte("video"), Steps: 2, Delay: 5 * time.Millisecond}
+	c := newTestDownloadContext(t, downloaderFunc(func(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		return fake.Download(ctx, req)
+	}))
+	c.Config.Jobs = 3
+
+	results := c.DownloadVideos(testVideos(videos))
+	if len(results) != videos {
+		t.Fatalf("got %d results, expected %d", len(results), videos)
+	}
+
+	for idx, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Video.Label(), r.Err)
+		}
+		// results are in the sorted order of the videos
+		if r.Video.Episode != idx+1 {
+			t.Errorf("result %d is episode %d, expected %d", idx, r.Video.Episode, idx+1)
+		}
+	}
+
+	if peak > c.Config.Jobs {
+		t.Errorf("%d downloads ran in parallel, expected at most %d", peak, c.Config.Jobs)
+	}
+	if len(fake.Requests) != videos {
+		t.Errorf("downloaded %d videos, expected %d", len(fake.Requests), videos)
+	}
+
+	for _, v := range testVideos(videos) {
+		path, downloaded, err := c.Downloaded(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !downloaded {
+			t.Errorf("%s is not marked as downloaded", v.Label())
+			continue
+		}
+		if filepath.Dir(path) != filepath.Join(c.Config.OutDir, "S01") {
+			t.Errorf("%s was downloaded to %s, expected the output directory", v.Label(), path)
+		}
+	}
+
+	// the staging directories are removed after the files were moved
+	entries, err := os.ReadDir(c.Config.TempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("found %d staging directories, expected none", len(entries))
+	}
+}
+
+func TestDownloadVideosSkipsDownloaded(t *testing.T) {
+	fake := &fakeDownloader{Data: []byte("video")}
+	c := newTestDownloadContext(t, fake)
+
+	videos := testVideos(3)
+	for _, r := range c.DownloadVideos(videos) {
+		if r.Err != nil {
+			t.Fatalf("%s: %v", r.Video.Label(), r.Err)
+		}
+	}
+
+	for _, r := range c.DownloadVideos(videos) {
+		if !errors.Is(r.Err, ErrSkipped) {
+			t.Errorf("%s: %v, expected %v", r.Video.Label(), r.Err, ErrSkipped)
+		}
+	}
+	if len(fake.Requests) != len(videos) {
+		t.Errorf("downloaded %d times, expected %d", len(fake.Requests), len(videos))
+	}
+
+	c.Config.Force = true
+	for _, r := range c.DownloadVideos(videos) {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Video.Label(), r.Err)
+		}
+	}
+	if len(fake.Requests) != 2*len(videos) {
+		t.Errorf("downloaded %d times, expected %d", len(fake.Requests), 2*len(videos))
+	}
+}
+
+func TestDownloadVideosCancelsPendingOnStop(t *testing.T) {
+	stop, cancel := context.WithCancel(context.Background())
+
+	started := make(chan struct{})
+	fake := &fakeDownloader{Data: []byte("video"), Delay: 20 * time.Millisecond}
+	c := newTestDownloadContext(t, downloaderFunc(func(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+		if req.Video.Episode == 1 {
+			close(started)
+			// the first interrupt arrives while the first download is running
+			cancel()
+		}
+		return fake.Download(ctx, req)
+	}))
+	c.Stop = stop
+	c.Config.Jobs = 1
+
+	results := c.DownloadVideos(testVideos(4))
+	<-started
+
+	// the running download finishes, pending ones are never started
+	if results[0].Err != nil {
+		t.Errorf("%s: %v, expected the running download to finish", results[0].Video.Label(), results[0].Err)
+	}
+	for _, r := range results[1:] {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("%s: %v, expected %v", r.Video.Label(), r.Err, context.Canceled)
+		}
+	}
+	if len(fake.Requests) != 1 {
+		t.Errorf("started %d downloads, expected 1", len(fake.Requests))
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	c := newTestDownloadContext(t, &fakeDownloader{})
+
+	failure := errors.New("boom")
+	results := []jobResult{
+		{Err: nil},
+		{Err: nil},
+		{Err: ErrSkipped},
+		{Err: fmt.Errorf("wrapped: %w", ErrSkipped)},
+		{Err: context.Canceled},
+		{Err: failure},
+		{Err: fmt.Errorf("S01E02: %w", hls.ErrDRM)},
+	}
+
+	err := c.summarize(results, time.Second)
+	if !errors.Is(err, failure) || !errors.Is(err, hls.ErrDRM) {
+		t.Errorf("summarize() = %v, expected all failures", err)
+	}
+	if errors.Is(err, ErrSkipped) || errors.Is(err, context.Canceled) {
+		t.Errorf("summarize() = %v, expected skipped and canceled downloads not to fail", err)
+	}
+
+	err = c.summarize([]jobResult{{}, {Err: ErrSkipped}, {Err: context.Canceled}}, time.Second)
+	if err != nil {
+		t.Errorf("summarize() = %v, expected no error", err)
+	}
+}
+
+func TestDownloadVideoStateTransitions(t *testing.T) {
+	v := testVideos(1)[0]
+
+	var (
+		c     *downloadContext
+		calls int
+	)
+	fake := &fakeDownloader{Data: []byte("video")}
+	c = newTestDownloadContext(t, downloaderFunc(func(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+		calls++
+
+		state, err := c.DownloadState(v.Language, v.Season, v.Episode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.Status != StatusRunning || state.Attempts != 1 {
+			t.Errorf("state while downloading = %s with %d attempts, expected %s with 1 attempt", state.Status, state.Attempts, StatusRunning)
+		}
+
+		if calls == 1 {
+			return DownloadResult{}, errors.New("connection reset")
+		}
+		return fake.Download(ctx, req)
+	}))
+
+	_, err := c.DownloadState(v.Language, v.Season, v.Episode)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("DownloadState() = %v, expected %v before the first download", err, ErrNotFound)
+	}
+
+	err = c.DownloadVideo(context.Background(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := c.DownloadState(v.Language, v.Season, v.Episode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != StatusDone || state.LastError != "" || state.Size != int64(len(fake.Data)) || state.SHA256 == "" {
+		t.Errorf("unexpected state after the download %+v", state)
+	}
+
+	attempts, err := c.DownloadAttempts(v, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("recorded %d attempts, expected 2", len(attempts))
+	}
+	// newest first
+	if a := attempts[0]; a.Attempt != 2 || a.Status != StatusDone || a.Error != "" {
+		t.Errorf("unexpected second attempt %+v", a)
+	}
+	if a := attempts[1]; a.Attempt != 1 || a.Status != StatusFailed || a.Error != "connection reset" {
+		t.Errorf("unexpected first attempt %+v", a)
+	}
+}
+
+func TestDownloadVideoFailedAndCanceledStates(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status DownloadStatus
+	}{
+		{"failed", fmt.Errorf("%w: not available", ErrNoStream), StatusFailed},
+		{"canceled", context.Canceled, StatusPending},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestDownloadContext(t, &fakeDownloader{Err: tt.err})
+			v := testVideos(1)[0]
+
+			err := c.DownloadVideo(context.Background(), v)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("DownloadVideo() = %v, expected %v", err, tt.err)
+			}
+
+			state, err := c.DownloadState(v.Language, v.Season, v.Episode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.Status != tt.status || state.Attempts != 1 {
+				t.Errorf("state = %s with %d attempts, expected %s with 1 attempt", state.Status, state.Attempts, tt.status)
+			}
+
+			attempts, err := c.DownloadAttempts(v, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(attempts) != 1 {
+				t.Errorf("recorded %d attempts, expected 1", len(attempts))
+			}
+		})
+	}
+}
+
+func TestResetRunning(t *testing.T) {
+	c := newTestDownloadContext(t, &fakeDownloader{})
+	v := testVideos(1)[0]
+
+	err := c.MarkRunning(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reset, err := c.ResetRunning()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reset != 1 {
+		t.Errorf("reset %d downloads, expected 1", reset)
+	}
+
+	state, err := c.DownloadState(v.Language, v.Season, v.Episode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != StatusPending {
+		t.Errorf("state = %s, expected %s", state.Status, StatusPending)
+	}
+}
+
+func TestDownloadVideoMovesSidecars(t *testing.T) {
+	image := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "jpeg")
+	}))
+	defer image.Close()
+
+	c := newTestDownloadContext(t, &fakeDownloader{Data: []byte("video")})
+	c.Config.Nfo = true
+
+	v := testVideos(1)[0]
+	v.ImageUrl = image.URL + "/thumb.jpg"
+
+	err := c.DownloadVideo(context.Background(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outDir := filepath.Join(c.Config.OutDir, "S01")
+	for _, name := range []string{
+		"South_Park_S01E01.mp4",
+		"South_Park_S01E01.nfo",
+		"South_Park_S01E01-thumb.jpg",
+		"season.nfo",
+	} {
+		_, err := os.Stat(filepath.Join(outDir, name))
+		if err != nil {
+			t.Errorf("expected %s in the output directory: %v", name, err)
+		}
+	}
+
+	_, err = os.Stat(filepath.Join(c.Config.OutDir, "tvshow.nfo"))
+	if err != nil {
+		t.Errorf("expected tvshow.nfo in the output directory: %v", err)
+	}
+
+	_, err = os.Stat(c.StagingDir(v))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the staging directory to be removed: %v", err)
+	}
+}
+
+func TestDownloadVideoKeepsInvalidFilesOutOfOutputDir(t *testing.T) {
+	c := newTestDownloadContext(t, &fakeDownloader{})
+	c.Config.Nfo = true
+
+	v := testVideos(1)[0]
+	err := c.DownloadVideo(context.Background(), v)
+	if !errors.Is(err, ErrInvalidDownload) {
+		t.Fatalf("DownloadVideo() = %v, expected %v", err, ErrInvalidDownload)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(c.Config.OutDir, "S01"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "season.nfo" {
+			t.Errorf("found %s of an invalid download in the output directory", e.Name())
+		}
+	}
+}
+
+func TestDownloadDryRun(t *testing.T) {
+	fake := &fakeDownloader{Data: []byte("video")}
+	c := newTestDownloadContext(t, fake)
+	c.Config.DryRun = true
+
+	var events bytes.Buffer
+	c.Events = NewEventWriter(&events)
+
+	videos := testVideos(2)
+	results := c.DownloadVideos(videos)
+	for _, r := range results {
+		if !errors.Is(r.Err, ErrSkipped) {
+			t.Errorf("%s: %v, expected %v", r.Video.Label(), r.Err, ErrSkipped)
+		}
+	}
+
+	err := c.summarize(results, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.Requests) != 0 {
+		t.Errorf("downloaded %d videos in a dry run", len(fake.Requests))
+	}
+	_, err = os.Stat(c.Config.OutDir)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a dry run not to create the output directory: %v", err)
+	}
+
+	var (
+		skipped int
+		summary DownloadSummary
+		dec     = json.NewDecoder(&events)
+	)
+	for dec.More() {
+		var e struct {
+			Type    string
+			Summary json.RawMessage
+		}
+		err = dec.Decode(&e)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch e.Type {
+		case EventDownloadSkipped:
+			skipped++
+		case EventRunSummary:
+			err = json.Unmarshal(e.Summary, &summary)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// the events and the summary agree
+	if skipped != len(videos) || summary.Skipped != len(videos) || summary.Downloaded != 0 {
+		t.Errorf("got %d skipped events and summary %+v, expected %d skipped videos", skipped, summary, len(videos))
+	}
+}
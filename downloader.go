@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/config"
+	"github.com/jxsl13/southpark-downloader/hls"
+	"github.com/jxsl13/southpark-downloader/utils"
+)
+
+// Downloader downloads the video of a single episode.
+type Downloader interface {
+	Download(ctx context.Context, req DownloadRequest) (DownloadResult, error)
+}
+
+// DownloadRequest describes the target of a download.
+type DownloadRequest struct {
+	Video Video
+	// directory of the downloaded file
+	Dir string
+	// file name without extension, the extension depends on the downloaded streams
+	FileName string
+	// Progress is called while the video is downloaded and may be nil.
+	Progress func(Progress)
+	// Log receives the output of child processes and may be nil.
+	Log io.Writer
+}
+
+// Path returns the path of the downloaded file with the given extension.
+func (r *DownloadRequest) Path(ext string) string {
+	return filepath.Join(r.Dir, r.FileName+ext)
+}
+
+func (r *DownloadRequest) progress(p Progress) {
+	if r.Progress != nil {
+		r.Progress(p)
+	}
+}
+
+// logf writes a line to the log of the request.
+func (r *DownloadRequest) logf(format string, args ...any) {
+	if r.Log != nil {
+		fmt.Fprintf(r.Log, format+"\n", args...)
+	}
+}
+
+// Progress of a running download.
+type Progress struct {
+	// downloaded bytes
+	Bytes int64
+	// estimated size of the download, 0 in case it is unknown
+	TotalBytes int64
+	// 0 to 100
+	Percent float64
+	// bytes per second
+	Speed float64
+	// estimated remaining time, 0 in case it is unknown
+	ETA time.Duration
+}
+
+// DownloadResult describes a successfully downloaded file.
+type DownloadResult struct {
+	// absolute path of the downloaded file
+	Path     string
+	Size     int64
+	Duration time.Duration
+	Backend  string
+}
+
+// NewDownloader returns the configured download backend.
+func NewDownloader(cfg *config.DownloadConfig) (Downloader, error) {
+	d, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.StallTimeout > 0 {
+		d = &stallDownloader{
+			Downloader: d,
+			Timeout:    cfg.StallTimeout,
+			Retries:    stallRetries,
+		}
+	}
+	return d, nil
+}
+
+func newBackend(cfg *config.DownloadConfig) (Downloader, error) {
+	// parallel fragment downloads of a single episode
+	fragments := max(1, runtime.NumCPU()/2)
+
+	switch cfg.Backend {
+	case config.BackendNative:
+		return &nativeDownloader{
+			Client:  hls.NewClient(cfg.UserAgent, fragments),
+			MicaUrl: micaUrl,
+		}, nil
+	case config.BackendYtDlp:
+		exe := "yt-dlp"
+		if runtime.GOOS == "windows" {
+			exe += ".cmd"
+		} else {
+			exe += ".sh"
+		}
+
+		cmd, err := filepath.Abs(filepath.Join(cfg.YouTubeDLDir, exe))
+		if err != nil {
+			return nil, err
+		}
+
+		return &ytDlpDownloader{
+			Cmd:       cmd,
+			MinRate:   cfg.MinRate,
+			Fragments: fragments,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown download backend: %s", cfg.Backend)
+	}
+}
+
+// newDownloadResult returns the result of a downloaded file.
+func newDownloadResult(backend, path string, start time.Time) (DownloadResult, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	return DownloadResult{
+		Path:     abs,
+		Size:     fi.Size(),
+		Duration: time.Since(start),
+		Backend:  backend,
+	}, nil
+}
+
+// FailureReason classifies the error of a failed download of any backend.
+func FailureReason(err error) utils.Reason {
+	var statusErr *hls.StatusError
+	switch {
+	case errors.Is(err, ErrGeoBlocked):
+		return utils.ReasonGeoBlock
+	case errors.Is(err, hls.ErrDRM):
+		return utils.ReasonDRM
+	case errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusForbidden:
+		return utils.ReasonForbidden
+	case errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound:
+		return utils.ReasonNotFound
+	}
+	return utils.ReasonOf(err)
+}
+
+// IsPermanent reports whether retrying a failed download is pointless.
+func IsPermanent(err error) bool {
+	if errors.Is(err, ErrNoStream) {
+		return true
+	}
+
+	switch FailureReason(err) {
+	case utils.ReasonNotFound, utils.ReasonGeoBlock, utils.ReasonDRM:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+// fakeDownloader writes Data instead of downloading a video, which allows
+// to exercise the download pipeline without network access or yt-dlp.
+type fakeDownloader struct {
+	Data []byte
+	// file extension including the dot, defaults to .mp4
+	Ext string
+	// number of progress updates, defaults to 1
+	Steps int
+	// delay between two progress updates
+	Delay time.Duration
+	// returned instead of downloading the video
+	Err error
+
+	mu sync.Mutex
+	// requests that were passed to Download
+	Requests []DownloadRequest
+}
+
+func (d *fakeDownloader) Download(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+	start := time.Now()
+	d.mu.Lock()
+	d.Requests = append(d.Requests, req)
+	d.mu.Unlock()
+
+	if d.Err != nil {
+		return DownloadResult{}, d.Err
+	}
+
+	var (
+		steps = max(1, d.Steps)
+		total = int64(len(d.Data))
+	)
+	for i := 1; i <= steps; i++ {
+		select {
+		case <-ctx.Done():
+			return DownloadResult{}, ctx.Err()
+		case <-time.After(d.Delay):
+		}
+
+		done := total * int64(i) / int64(steps)
+		req.progress(Progress{
+			Bytes:      done,
+			TotalBytes: total,
+			Percent:    100 * float64(i) / float64(steps),
+			ETA:        time.Duration(steps-i) * d.Delay,
+		})
+	}
+
+	ext := d.Ext
+	if ext == "" {
+		ext = ".mp4"
+	}
+
+	path := req.Path(ext)
+	err := os.WriteFile(path, d.Data, 0644)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	return newDownloadResult("fake", path, start)
+}
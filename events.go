@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/config"
+)
+
+// event types of the json output, the names are part of the public interface
+const (
+	EventPageFetched       = "page_fetched"
+	EventEpisodeDiscovered = "episode_discovered"
+	EventEpisodeUpdated    = "episode_updated"
+	EventDownloadQueued    = "download_queued"
+	EventDownloadStarted   = "download_started"
+	EventDownloadProgress  = "download_progress"
+	EventDownloadFinished  = "download_finished"
+	EventDownloadSkipped   = "download_skipped"
+	EventDownloadFailed    = "download_failed"
+	EventDownloadRetrying  = "download_retrying"
+	EventDownloadCanceled  = "download_canceled"
+	EventRunSummary        = "run_summary"
+)
+
+// Event is a single line of the json output.
+type Event struct {
+	Time    time.Time     `json:"time"`
+	Type    string        `json:"type"`
+	Episode *EpisodeEvent `json:"episode,omitempty"`
+
+	// fetched page
+	Url    string `json:"url,omitempty"`
+	Status int    `json:"status,omitempty"`
+
+	// finished download
+	Path     string  `json:"path,omitempty"`
+	Size     int64   `json:"size,omitempty"`
+	Backend  string  `json:"backend,omitempty"`
+	Duration float64 `json:"duration_seconds,omitempty"`
+
+	Progress *ProgressEvent `json:"progress,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+	Error    string         `json:"error,omitempty"`
+
+	// retried download
+	Attempt int     `json:"attempt,omitempty"`
+	RetryIn float64 `json:"retry_in_seconds,omitempty"`
+
+	// either a ScrapeSummary or a DownloadSummary
+	Summary any `json:"summary,omitempty"`
+}
+
+// EpisodeEvent identifies the video of an event.
+type EpisodeEvent struct {
+	Id       string `json:"id"`
+	Language string `json:"language"`
+	Season   int    `json:"season"`
+	Episode  int    `json:"episode"`
+	Title    string `json:"title"`
+	Url      string `json:"url"`
+}
+
+type ProgressEvent struct {
+	Bytes      int64   `json:"bytes"`
+	TotalBytes int64   `json:"total_bytes"`
+	Percent    float64 `json:"percent"`
+	// bytes per second
+	Speed float64 `json:"speed"`
+	ETA   float64 `json:"eta_seconds"`
+}
+
+type ScrapeSummary struct {
+	Command    string `json:"command"`
+	Pages      int    `json:"pages"`
+	Discovered int    `json:"discovered"`
+	Updated    int    `json:"updated"`
+	// only set by scrape --repair
+	Repaired  int `json:"repaired,omitempty"`
+	Remaining int `json:"remaining,omitempty"`
+}
+
+type DownloadSummary struct {
+	Command    string  `json:"command"`
+	Total      int     `json:"total"`
+	Downloaded int     `json:"downloaded"`
+	Skipped    int     `json:"skipped"`
+	Failed     int     `json:"failed"`
+	Canceled   int     `json:"canceled"`
+	Duration   float64 `json:"duration_seconds"`
+}
+
+func newEpisodeEvent(v Video) *EpisodeEvent {
+	return &EpisodeEvent{
+		Id:       v.Identifier(),
+		Language: v.Language,
+		Season:   v.Season,
+		Episode:  v.Episode,
+		Title:    v.Title,
+		Url:      v.Url,
+	}
+}
+
+func newProgressEvent(p Progress) *ProgressEvent {
+	return &ProgressEvent{
+		Bytes:      p.Bytes,
+		TotalBytes: p.TotalBytes,
+		Percent:    p.Percent,
+		Speed:      p.Speed,
+		ETA:        p.ETA.Seconds(),
+	}
+}
+
+// EventWriter writes newline delimited json events.
+// A nil EventWriter discards all events.
+type EventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewEventWriter(w io.Writer) *EventWriter {
+	return &EventWriter{enc: json.NewEncoder(w)}
+}
+
+// InitEvents enables the json output on stdout. Stdout only contains events afterwards,
+// human readable output is written to TextOutput instead.
+func (c *rootContext) InitEvents(cfg *config.OutputConfig) {
+	if cfg.Output != config.OutputJSON || c.Events != nil {
+		return
+	}
+
+	c.Events = NewEventWriter(os.Stdout)
+}
+
+// TextOutput returns the destination of human readable output like the progress display,
+// which is stderr in case stdout contains json events.
+func (c *rootContext) TextOutput() io.Writer {
+	if c.Events != nil {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func (w *EventWriter) Emit(e Event) {
+	if w == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.enc.Encode(e)
+	if err != nil {
+		slog.Error("failed to write event", "error", err)
+	}
+}
+
+// EmitVideo emits an event of a video.
+func (w *EventWriter) EmitVideo(eventType string, v Video, e Event) {
+	e.Type = eventType
+	e.Episode = newEpisodeEvent(v)
+	w.Emit(e)
+}
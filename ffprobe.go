@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/utils"
+)
+
+// ProbeDuration returns the container duration of a media file.
+func ProbeDuration(ctx context.Context, filePath string) (time.Duration, error) {
+	lines, err := utils.ExecuteQuietPathApplicationWithOutput(
+		ctx,
+		"",
+		"ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		filePath,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		seconds, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q of %s: %w", line, filePath, err)
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	return 0, fmt.Errorf("%w: duration of %s", ErrNotFound, filePath)
+}
+
+// Stream is a single stream of a media file.
+type Stream struct {
+	// e.g. video, audio and subtitle
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+}
+
+// ProbeStreams returns the streams of a media file in the order of the file.
+func ProbeStreams(ctx context.Context, filePath string) ([]Stream, error) {
+	lines, err := utils.ExecuteQuietPathApplicationWithOutput(
+		ctx,
+		"",
+		"ffprobe",
+		"-v", "error",
+		"-show_entries", "stream=codec_type,codec_name",
+		"-of", "json",
+		filePath,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var probe struct {
+		Streams []Stream `json:"streams"`
+	}
+	err = json.Unmarshal([]byte(strings.Join(lines, "\n")), &probe)
+	if err != nil {
+		return nil, fmt.Errorf("invalid streams of %s: %w", filePath, err)
+	}
+	return probe.Streams, nil
+}
@@ -0,0 +1,349 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported stream")
+	ErrDRM         = errors.New("DRM protected")
+	ErrStatus      = errors.New("unexpected status")
+)
+
+// StatusError is returned in case a resource is answered with an unexpected status.
+type StatusError struct {
+	URI        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%v: could not get %s: %s", ErrStatus, e.URI, e.Status)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrStatus
+}
+
+// Client downloads HLS playlists and their segments.
+type Client struct {
+	HTTP      *http.Client
+	UserAgent string
+	// number of segments that are downloaded in parallel
+	Concurrency int
+	// number of retries of a failed request
+	Retries    int
+	RetryDelay time.Duration
+
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+// NewClient returns a client with sensible defaults.
+func NewClient(userAgent string, concurrency int) *Client {
+	return &Client{
+		HTTP:        http.DefaultClient,
+		UserAgent:   userAgent,
+		Concurrency: max(1, concurrency),
+		Retries:     5,
+		RetryDelay:  time.Second,
+	}
+}
+
+// Get returns the body of a resource and retries transient errors.
+func (c *Client) Get(ctx context.Context, uri string) ([]byte, error) {
+	return c.get(ctx, uri, nil)
+}
+
+// Playlist downloads and parses a master or media playlist.
+func (c *Client) Playlist(ctx context.Context, uri string) (*MasterPlaylist, *MediaPlaylist, error) {
+	base, err := url.Parse(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := c.Get(ctx, uri)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	master, media, err := Parse(bytes.NewReader(data), base)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", uri, err)
+	}
+	return master, media, nil
+}
+
+// MediaPlaylist downloads a media playlist.
+func (c *Client) MediaPlaylist(ctx context.Context, uri string) (*MediaPlaylist, error) {
+	_, media, err := c.Playlist(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	if media == nil {
+		return nil, fmt.Errorf("%w: %s: expected a media playlist", ErrInvalidPlaylist, uri)
+	}
+	return media, nil
+}
+
+// Download fetches the decrypted segments of the playlist concurrently and writes
+// them in order to w. progress is called with the number of written segments and bytes
+// after every segment and may be nil.
+func (c *Client) Download(ctx context.Context, p *MediaPlaylist, w io.Writer, progress func(done, total int, written int64)) error {
+	return c.DownloadFrom(ctx, p, w, 0, progress)
+}
+
+// DownloadFrom resumes a download that was interrupted after the first from segments.
+// w must contain exactly those segments, the initialization section is only written when
+// starting from the first segment. progress is called with the total number of written
+// segments and the bytes that were written by this call.
+func (c *Client) DownloadFrom(ctx context.Context, p *MediaPlaylist, w io.Writer, from int, progress func(done, total int, written int64)) error {
+	var written int64
+
+	if !p.EndList {
+		return fmt.Errorf("%w: live streams cannot be downloaded", ErrUnsupported)
+	}
+
+	if from < 0 || from > len(p.Segments) {
+		return fmt.Errorf("invalid first segment %d of %d segments", from, len(p.Segments))
+	}
+
+	if p.Map != nil && from == 0 {
+		data, err := c.get(ctx, p.Map.URI, p.Map.ByteRange)
+		if err != nil {
+			return fmt.Errorf("failed to download initialization section: %w", err)
+		}
+		n, err := w.Write(data)
+		written += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	type result struct {
+		data []byte
+		err  error
+	}
+
+	var (
+		total   = len(p.Segments)
+		results = make([]chan result, total)
+		queue   = make(chan int)
+		// limits the number of downloaded segments that wait to be written
+		window = make(chan struct{}, 2*max(1, c.Concurrency))
+		wg     sync.WaitGroup
+	)
+
+	for idx := range results {
+		results[idx] = make(chan result, 1)
+	}
+
+	wg.Add(max(1, c.Concurrency))
+	for i := 0; i < max(1, c.Concurrency); i++ {
+		go func() {
+			defer wg.Done()
+			for idx := range queue {
+				data, err := c.segment(ctx, p.Segments[idx])
+				results[idx] <- result{data, err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(queue)
+		for idx := from; idx < total; idx++ {
+			select {
+			case <-ctx.Done():
+				return
+			case window <- struct{}{}:
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case queue <- idx:
+			}
+		}
+	}()
+
+	// workers must not outlive the download
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	for idx := from; idx < total; idx++ {
+		var r result
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case r = <-results[idx]:
+		}
+		<-window
+
+		if r.err != nil {
+			return fmt.Errorf("segment %d of %d: %w", idx+1, total, r.err)
+		}
+
+		n, err := w.Write(r.data)
+		written += int64(n)
+		if err != nil {
+			return err
+		}
+
+		if progress != nil {
+			progress(idx+1, total, written)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) segment(ctx context.Context, s Segment) ([]byte, error) {
+	data, err := c.get(ctx, s.URI, s.ByteRange)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Key == nil {
+		return data, nil
+	}
+
+	if s.Key.Method != MethodAES128 {
+		return nil, fmt.Errorf("%w: %w: %s encrypted segments", ErrUnsupported, ErrDRM, s.Key.Method)
+	}
+
+	key, err := c.key(ctx, s.Key.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := s.Key.IV
+	if iv == nil {
+		// the media sequence number is the default initialization vector
+		iv = make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(s.Sequence))
+	}
+
+	return decrypt(data, key, iv)
+}
+
+// key returns the cached AES-128 key.
+func (c *Client) key(ctx context.Context, uri string) ([]byte, error) {
+	c.mu.Lock()
+	key, found := c.keys[uri]
+	c.mu.Unlock()
+	if found {
+		return key, nil
+	}
+
+	key, err := c.Get(ctx, uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key: %w", err)
+	}
+	if len(key) != aes.BlockSize {
+		return nil, fmt.Errorf("%w: invalid key length %d: %s", ErrUnsupported, len(key), uri)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys == nil {
+		c.keys = make(map[string][]byte)
+	}
+	c.keys[uri] = key
+	return key, nil
+}
+
+func decrypt(data, key, iv []byte) ([]byte, error) {
+	if len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("encrypted segment size %d is not a multiple of the block size", len(data))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+
+	// PKCS#7 padding
+	if len(data) == 0 {
+		return data, nil
+	}
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(data) {
+		return nil, fmt.Errorf("invalid padding of decrypted segment")
+	}
+	return data[:len(data)-padding], nil
+}
+
+func (c *Client) get(ctx context.Context, uri string, br *ByteRange) (data []byte, err error) {
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			// linear backoff between attempts
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(attempt) * c.RetryDelay):
+			}
+		}
+
+		var retry bool
+		data, retry, err = c.fetch(ctx, uri, br)
+		if err == nil || !retry {
+			return data, err
+		}
+	}
+	return nil, err
+}
+
+// fetch requests a resource once and reports whether a failure may be retried.
+func (c *Client) fetch(ctx context.Context, uri string, br *ByteRange) (data []byte, retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	if br != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", br.Offset, br.Offset+br.Length-1))
+	}
+
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, fmt.Errorf("could not get %s: %w", uri, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK, resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return nil, true, &StatusError{URI: uri, StatusCode: resp.StatusCode, Status: resp.Status}
+	default:
+		return nil, false, &StatusError{URI: uri, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, ctx.Err() == nil, fmt.Errorf("could not read %s: %w", uri, err)
+	}
+	return data, false, nil
+}
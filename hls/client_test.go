@@ -0,0 +1,562 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// server serves fixed responses per path and counts the requests.
+type server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	handlers map[string]http.HandlerFunc
+	requests map[string]int
+}
+
+func newServer(t *testing.T) *server {
+	s := &server{
+		handlers: make(map[string]http.HandlerFunc),
+		requests: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		h, found := s.handlers[r.URL.Path]
+		s.mu.Unlock()
+
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		h(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *server) Handle(path string, h http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[path] = h
+}
+
+// Content serves data and supports range requests.
+func (s *server) Content(path string, data []byte) {
+	s.Handle(path, func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, path, time.Time{}, bytes.NewReader(data))
+	})
+}
+
+func (s *server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func newTestClient(concurrency int) *Client {
+	c := NewClient("test-agent", concurrency)
+	c.Retries = 3
+	c.RetryDelay = time.Millisecond
+	return c
+}
+
+// encrypt encrypts data with AES-128 in CBC mode and PKCS#7 padding.
+func encrypt(t *testing.T, data, key, iv []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	out := append(bytes.Clone(data), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, out)
+	return out
+}
+
+func sequenceIV(sequence int) []byte {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	return iv
+}
+
+func TestClientPlaylist(t *testing.T) {
+	s := newServer(t)
+	s.Content("/master.m3u8", []byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=100\nmedia.m3u8\n"))
+	s.Content("/media.m3u8", []byte("#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXTINF:1,\nseg.ts\n#EXT-X-ENDLIST\n"))
+	s.Content("/broken.m3u8", []byte("not a playlist"))
+
+	c := newTestClient(1)
+	ctx := context.Background()
+
+	master, media, err := c.Playlist(ctx, s.URL+"/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media != nil || len(master.Variants) != 1 || master.Variants[0].URI != s.URL+"/media.m3u8" {
+		t.Fatalf("unexpected master playlist %+v", master)
+	}
+
+	media, err = c.MediaPlaylist(ctx, master.Variants[0].URI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(media.Segments) != 1 || media.Segments[0].URI != s.URL+"/seg.ts" {
+		t.Errorf("unexpected segments %+v", media.Segments)
+	}
+
+	_, err = c.MediaPlaylist(ctx, s.URL+"/master.m3u8")
+	if !errors.Is(err, ErrInvalidPlaylist) {
+		t.Errorf("MediaPlaylist(master) = %v, expected %v", err, ErrInvalidPlaylist)
+	}
+
+	_, _, err = c.Playlist(ctx, s.URL+"/broken.m3u8")
+	if !errors.Is(err, ErrInvalidPlaylist) {
+		t.Errorf("Playlist(broken) = %v, expected %v", err, ErrInvalidPlaylist)
+	}
+}
+
+func TestClientSendsUserAgent(t *testing.T) {
+	s := newServer(t)
+	var agent atomic.Value
+	s.Handle("/data", func(w http.ResponseWriter, r *http.Request) {
+		agent.Store(r.UserAgent())
+	})
+
+	_, err := newTestClient(1).Get(context.Background(), s.URL+"/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := agent.Load(); got != "test-agent" {
+		t.Errorf("user agent = %v, expected %q", got, "test-agent")
+	}
+}
+
+func TestClientRetriesTransientErrors(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			s := newServer(t)
+			var attempts atomic.Int32
+			s.Handle("/data", func(w http.ResponseWriter, r *http.Request) {
+				if attempts.Add(1) < 3 {
+					w.WriteHeader(status)
+					return
+				}
+				fmt.Fprint(w, "ok")
+			})
+
+			data, err := newTestClient(1).Get(context.Background(), s.URL+"/data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "ok" {
+				t.Errorf("body = %q, expected %q", data, "ok")
+			}
+			if n := attempts.Load(); n != 3 {
+				t.Errorf("made %d requests, expected 3", n)
+			}
+		})
+	}
+}
+
+func TestClientGivesUpAfterRetries(t *testing.T) {
+	s := newServer(t)
+	s.Handle("/data", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	c := newTestClient(1)
+	_, err := c.Get(context.Background(), s.URL+"/data")
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Get() = %v, expected a %d status error", err, http.StatusServiceUnavailable)
+	}
+	if n := s.Requests("/data"); n != c.Retries+1 {
+		t.Errorf("made %d requests, expected %d", n, c.Retries+1)
+	}
+}
+
+func TestClientDoesNotRetryClientErrors(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusGone} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			s := newServer(t)
+			s.Handle("/data", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+			})
+
+			_, err := newTestClient(1).Get(context.Background(), s.URL+"/data")
+			if !errors.Is(err, ErrStatus) {
+				t.Fatalf("Get() = %v, expected %v", err, ErrStatus)
+			}
+
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != status {
+				t.Errorf("Get() = %v, expected status %d", err, status)
+			}
+			if n := s.Requests("/data"); n != 1 {
+				t.Errorf("made %d requests, expected 1", n)
+			}
+		})
+	}
+}
+
+func TestClientRetryStopsOnCancel(t *testing.T) {
+	s := newServer(t)
+	s.Handle("/data", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	c := newTestClient(1)
+	c.RetryDelay = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := c.Get(ctx, s.URL+"/data")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get() = %v, expected %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestDownloadDecrypts(t *testing.T) {
+	var (
+		s        = newServer(t)
+		key      = []byte("0123456789abcdef")
+		explicit = []byte("fedcba9876543210")
+		plain    = [][]byte{
+			[]byte("segment with an explicit iv"),
+			[]byte("segment with the sequence number as iv"),
+			[]byte("exactly sixteen!"),
+			[]byte("unencrypted segment"),
+		}
+	)
+	const sequence = 41
+
+	s.Content("/key", key)
+	s.Content("/seg0.ts", encrypt(t, plain[0], key, explicit))
+	s.Content("/seg1.ts", encrypt(t, plain[1], key, sequenceIV(sequence+1)))
+	s.Content("/seg2.ts", encrypt(t, plain[2], key, sequenceIV(sequence+2)))
+	s.Content("/seg3.ts", plain[3])
+	s.Content("/index.m3u8", []byte(fmt.Sprintf(`#EXTM3U
+#EXT-X-TARGETDURATION:1
+#EXT-X-MEDIA-SEQUENCE:%d
+#EXT-X-KEY:METHOD=AES-128,URI="key",IV=0x%x
+#EXTINF:1,
+seg0.ts
+#EXT-X-KEY:METHOD=AES-128,URI="key"
+#EXTINF:1,
+seg1.ts
+#EXTINF:1,
+seg2.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:1,
+seg3.ts
+#EXT-X-ENDLIST
+`, sequence, explicit)))
+
+	// a single worker, concurrent workers may request an uncached key at the same time
+	c := newTestClient(1)
+	ctx := context.Background()
+
+	media, err := c.MediaPlaylist(ctx, s.URL+"/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = c.Download(ctx, media, &out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := bytes.Join(plain, nil); !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("downloaded %q, expected %q", out.Bytes(), want)
+	}
+	if n := s.Requests("/key"); n != 1 {
+		t.Errorf("requested the key %d times, expected it to be cached", n)
+	}
+}
+
+func TestDownloadRejectsInvalidKey(t *testing.T) {
+	s := newServer(t)
+	s.Content("/key", []byte("short"))
+	s.Content("/seg.ts", make([]byte, aes.BlockSize))
+
+	p := &MediaPlaylist{
+		EndList: true,
+		Segments: []Segment{
+			{URI: s.URL + "/seg.ts", Key: &Key{Method: MethodAES128, URI: s.URL + "/key"}},
+		},
+	}
+
+	err := newTestClient(1).Download(context.Background(), p, &bytes.Buffer{}, nil)
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Download() = %v, expected %v", err, ErrUnsupported)
+	}
+}
+
+func TestDownloadRejectsSampleAES(t *testing.T) {
+	s := newServer(t)
+	s.Content("/seg.ts", make([]byte, aes.BlockSize))
+
+	p := &MediaPlaylist{
+		EndList: true,
+		Segments: []Segment{
+			{URI: s.URL + "/seg.ts", Key: &Key{Method: MethodSampleAES, URI: s.URL + "/key"}},
+		},
+	}
+
+	err := newTestClient(1).Download(context.Background(), p, &bytes.Buffer{}, nil)
+	if !errors.Is(err, ErrDRM) {
+		t.Errorf("Download() = %v, expected %v", err, ErrDRM)
+	}
+}
+
+func TestDownloadRejectsLiveStreams(t *testing.T) {
+	p := &MediaPlaylist{Segments: []Segment{{URI: "http://localhost/seg.ts"}}}
+
+	err := newTestClient(1).Download(context.Background(), p, &bytes.Buffer{}, nil)
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Download() = %v, expected %v", err, ErrUnsupported)
+	}
+}
+
+// orderedPlaylist serves n segments, earlier segments are answered slower than later ones.
+func orderedPlaylist(t *testing.T, s *server, n int) *MediaPlaylist {
+	p := &MediaPlaylist{EndList: true}
+	for idx := 0; idx < n; idx++ {
+		path := fmt.Sprintf("/seg%d.ts", idx)
+		delay := time.Duration(n-idx) * time.Millisecond
+		data := []byte(fmt.Sprintf("<%d>", idx))
+
+		s.Handle(path, func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			w.Write(data)
+		})
+		p.Segments = append(p.Segments, Segment{URI: s.URL + path, Duration: 1, Sequence: idx})
+	}
+	return p
+}
+
+func expectedSegments(from, to int) string {
+	var sb strings.Builder
+	for idx := from; idx < to; idx++ {
+		fmt.Fprintf(&sb, "<%d>", idx)
+	}
+	return sb.String()
+}
+
+func TestDownloadKeepsSegmentOrder(t *testing.T) {
+	const segments = 20
+
+	s := newServer(t)
+	p := orderedPlaylist(t, s, segments)
+
+	var (
+		out      bytes.Buffer
+		progress []int
+		written  int64
+	)
+	err := newTestClient(4).Download(context.Background(), p, &out, func(done, total int, n int64) {
+		if total != segments {
+			t.Errorf("progress total = %d, expected %d", total, segments)
+		}
+		progress = append(progress, done)
+		written = n
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := expectedSegments(0, segments); out.String() != want {
+		t.Errorf("downloaded %q, expected %q", out.String(), want)
+	}
+	if written != int64(out.Len()) {
+		t.Errorf("progress reported %d written bytes, expected %d", written, out.Len())
+	}
+	for idx, done := range progress {
+		if done != idx+1 {
+			t.Fatalf("progress = %v, expected one call per segment in order", progress)
+		}
+	}
+}
+
+func TestDownloadFailsOnSegmentError(t *testing.T) {
+	s := newServer(t)
+	p := orderedPlaylist(t, s, 6)
+	p.Segments[3].URI = s.URL + "/missing.ts"
+
+	var out bytes.Buffer
+	err := newTestClient(3).Download(context.Background(), p, &out, nil)
+	if !errors.Is(err, ErrStatus) {
+		t.Fatalf("Download() = %v, expected %v", err, ErrStatus)
+	}
+	if !strings.Contains(err.Error(), "segment 4 of 6") {
+		t.Errorf("Download() = %v, expected the failed segment to be named", err)
+	}
+
+	// segments before the failed one are written
+	if want := expectedSegments(0, 3); out.String() != want {
+		t.Errorf("downloaded %q, expected %q", out.String(), want)
+	}
+}
+
+func TestDownloadFrom(t *testing.T) {
+	const segments = 8
+
+	s := newServer(t)
+	p := orderedPlaylist(t, s, segments)
+	s.Content("/init.mp4", []byte("<init>"))
+	p.Map = &Map{URI: s.URL + "/init.mp4"}
+
+	c := newTestClient(3)
+
+	var full bytes.Buffer
+	err := c.Download(context.Background(), p, &full, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<init>" + expectedSegments(0, segments); full.String() != want {
+		t.Errorf("downloaded %q, expected %q", full.String(), want)
+	}
+
+	const from = 5
+	var (
+		resumed bytes.Buffer
+		first   = -1
+		written int64
+	)
+	err = c.DownloadFrom(context.Background(), p, &resumed, from, func(done, total int, n int64) {
+		if first < 0 {
+			first = done
+		}
+		written = n
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the initialization section is part of the first segments
+	if want := expectedSegments(from, segments); resumed.String() != want {
+		t.Errorf("resumed %q, expected %q", resumed.String(), want)
+	}
+	if first != from+1 {
+		t.Errorf("first progress reported %d done segments, expected %d", first, from+1)
+	}
+	if written != int64(resumed.Len()) {
+		t.Errorf("progress reported %d written bytes, expected %d", written, resumed.Len())
+	}
+	for idx := 0; idx < from; idx++ {
+		if n := s.Requests(fmt.Sprintf("/seg%d.ts", idx)); n != 1 {
+			t.Errorf("requested segment %d %d times, expected it to be skipped on resume", idx, n)
+		}
+	}
+
+	var done bytes.Buffer
+	err = c.DownloadFrom(context.Background(), p, &done, segments, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done.Len() != 0 {
+		t.Errorf("resuming a complete download wrote %q", done.String())
+	}
+
+	for _, from := range []int{-1, segments + 1} {
+		err = c.DownloadFrom(context.Background(), p, &bytes.Buffer{}, from, nil)
+		if err == nil {
+			t.Errorf("DownloadFrom(%d) succeeded, expected an error", from)
+		}
+	}
+}
+
+func TestDownloadByteRanges(t *testing.T) {
+	s := newServer(t)
+	s.Content("/main.mp4", []byte("INIT|first|second|third"))
+	s.Content("/index.m3u8", []byte(`#EXTM3U
+#EXT-X-TARGETDURATION:1
+#EXT-X-MAP:URI="main.mp4",BYTERANGE="5@0"
+#EXTINF:1,
+#EXT-X-BYTERANGE:6@5
+main.mp4
+#EXTINF:1,
+#EXT-X-BYTERANGE:7
+main.mp4
+#EXTINF:1,
+#EXT-X-BYTERANGE:5
+main.mp4
+#EXT-X-ENDLIST
+`))
+
+	c := newTestClient(2)
+	media, err := c.MediaPlaylist(context.Background(), s.URL+"/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = c.Download(context.Background(), media, &out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "INIT|first|second|third"; out.String() != want {
+		t.Errorf("downloaded %q, expected %q", out.String(), want)
+	}
+}
+
+func TestDownloadCancel(t *testing.T) {
+	s := newServer(t)
+	block := make(chan struct{})
+	t.Cleanup(func() { close(block) })
+	s.Handle("/seg.ts", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	})
+
+	p := &MediaPlaylist{EndList: true}
+	for idx := 0; idx < 10; idx++ {
+		p.Segments = append(p.Segments, Segment{URI: s.URL + "/seg.ts", Sequence: idx})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := newTestClient(4).Download(ctx, p, &bytes.Buffer{}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Download() = %v, expected %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestDecryptInvalidData(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := make([]byte, aes.BlockSize)
+
+	_, err := decrypt([]byte("not a block"), key, iv)
+	if err == nil {
+		t.Errorf("decrypting a partial block succeeded, expected an error")
+	}
+
+	// a block that decrypts to an invalid padding byte
+	data := make([]byte, aes.BlockSize)
+	block, _ := aes.NewCipher(key)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	_, err = decrypt(data, key, iv)
+	if err == nil {
+		t.Errorf("decrypting invalid padding succeeded, expected an error")
+	}
+}
@@ -0,0 +1,372 @@
+package hls
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidPlaylist = errors.New("invalid playlist")
+
+// MasterPlaylist lists the variant streams of a presentation.
+type MasterPlaylist struct {
+	Variants   []Variant
+	Renditions []Rendition
+}
+
+// Variant is a single quality level of a presentation.
+type Variant struct {
+	URI        string
+	Bandwidth  int
+	Resolution string
+	Codecs     string
+	// group id of the alternative audio renditions
+	Audio string
+}
+
+// Rendition is an alternative audio, video or subtitle stream (EXT-X-MEDIA).
+type Rendition struct {
+	Type     string
+	GroupID  string
+	Language string
+	Name     string
+	Default  bool
+	// empty in case the rendition is part of the variant stream
+	URI string
+}
+
+// MediaPlaylist lists the segments of a single stream.
+type MediaPlaylist struct {
+	TargetDuration int
+	MediaSequence  int
+	// initialization section of fragmented MP4 segments
+	Map      *Map
+	Segments []Segment
+	EndList  bool
+}
+
+// Segment is a single media segment.
+type Segment struct {
+	URI      string
+	Duration float64
+	Sequence int
+	// nil in case the segment is not encrypted
+	Key       *Key
+	ByteRange *ByteRange
+}
+
+// Key describes how a segment is encrypted.
+type Key struct {
+	Method string
+	URI    string
+	// nil in case the media sequence number is used as initialization vector
+	IV []byte
+}
+
+// Map is the initialization section of a media playlist.
+type Map struct {
+	URI       string
+	ByteRange *ByteRange
+}
+
+// ByteRange is a sub-range of a resource.
+type ByteRange struct {
+	Length int64
+	Offset int64
+}
+
+const (
+	MethodNone      = "NONE"
+	MethodAES128    = "AES-128"
+	MethodSampleAES = "SAMPLE-AES"
+)
+
+// Parse parses either a master or a media playlist. Exactly one of the returned
+// playlists is non-nil. Relative URIs are resolved against base.
+func Parse(r io.Reader, base *url.URL) (*MasterPlaylist, *MediaPlaylist, error) {
+	var (
+		scanner = bufio.NewScanner(r)
+		master  = &MasterPlaylist{}
+		media   = &MediaPlaylist{}
+		isMedia bool
+		first   = true
+
+		// state of the next uri line
+		variant   *Variant
+		duration  float64
+		key       *Key
+		byteRange *ByteRange
+		// offset of the next byte range without explicit offset
+		nextOffset int64
+	)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if first {
+			if line != "#EXTM3U" {
+				return nil, nil, fmt.Errorf("%w: missing #EXTM3U header", ErrInvalidPlaylist)
+			}
+			first = false
+			continue
+		}
+
+		if !strings.HasPrefix(line, "#") {
+			uri, err := resolve(base, line)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if variant != nil {
+				variant.URI = uri
+				master.Variants = append(master.Variants, *variant)
+				variant = nil
+				continue
+			}
+
+			isMedia = true
+			if byteRange != nil {
+				if byteRange.Offset < 0 {
+					byteRange.Offset = nextOffset
+				}
+				nextOffset = byteRange.Offset + byteRange.Length
+			}
+			media.Segments = append(media.Segments, Segment{
+				URI:       uri,
+				Duration:  duration,
+				Sequence:  media.MediaSequence + len(media.Segments),
+				Key:       key,
+				ByteRange: byteRange,
+			})
+			duration = 0
+			byteRange = nil
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+		switch tag {
+		case "#EXT-X-STREAM-INF":
+			attrs := parseAttributes(value)
+			bandwidth, _ := strconv.Atoi(attrs["BANDWIDTH"])
+			variant = &Variant{
+				Bandwidth:  bandwidth,
+				Resolution: attrs["RESOLUTION"],
+				Codecs:     attrs["CODECS"],
+				Audio:      attrs["AUDIO"],
+			}
+		case "#EXT-X-MEDIA":
+			attrs := parseAttributes(value)
+			rendition := Rendition{
+				Type:     attrs["TYPE"],
+				GroupID:  attrs["GROUP-ID"],
+				Language: attrs["LANGUAGE"],
+				Name:     attrs["NAME"],
+				Default:  attrs["DEFAULT"] == "YES",
+			}
+			if attrs["URI"] != "" {
+				uri, err := resolve(base, attrs["URI"])
+				if err != nil {
+					return nil, nil, err
+				}
+				rendition.URI = uri
+			}
+			master.Renditions = append(master.Renditions, rendition)
+		case "#EXT-X-TARGETDURATION":
+			isMedia = true
+			media.TargetDuration, _ = strconv.Atoi(value)
+		case "#EXT-X-MEDIA-SEQUENCE":
+			isMedia = true
+			media.MediaSequence, _ = strconv.Atoi(value)
+		case "#EXTINF":
+			isMedia = true
+			d, _, _ := strings.Cut(value, ",")
+			var err error
+			duration, err = strconv.ParseFloat(d, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: invalid segment duration: %q", ErrInvalidPlaylist, value)
+			}
+		case "#EXT-X-BYTERANGE":
+			br, err := parseByteRange(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			byteRange = br
+		case "#EXT-X-KEY":
+			k, err := parseKey(base, value)
+			if err != nil {
+				return nil, nil, err
+			}
+			key = k
+		case "#EXT-X-MAP":
+			attrs := parseAttributes(value)
+			uri, err := resolve(base, attrs["URI"])
+			if err != nil {
+				return nil, nil, err
+			}
+			media.Map = &Map{URI: uri}
+			if attrs["BYTERANGE"] != "" {
+				br, err := parseByteRange(attrs["BYTERANGE"])
+				if err != nil {
+					return nil, nil, err
+				}
+				if br.Offset < 0 {
+					br.Offset = 0
+				}
+				media.Map.ByteRange = br
+			}
+		case "#EXT-X-ENDLIST":
+			media.EndList = true
+		}
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if first {
+		return nil, nil, fmt.Errorf("%w: empty playlist", ErrInvalidPlaylist)
+	}
+
+	if isMedia {
+		return nil, media, nil
+	}
+
+	if len(master.Variants) == 0 {
+		return nil, nil, fmt.Errorf("%w: neither variants nor segments found", ErrInvalidPlaylist)
+	}
+	return master, nil, nil
+}
+
+// BestVariant returns the variant with the highest bandwidth and its alternative
+// audio rendition, which is nil in case the audio is part of the variant stream.
+func (p *MasterPlaylist) BestVariant() (Variant, *Rendition) {
+	best := p.Variants[0]
+	for _, v := range p.Variants[1:] {
+		if v.Bandwidth > best.Bandwidth {
+			best = v
+		}
+	}
+
+	if best.Audio == "" {
+		return best, nil
+	}
+
+	var audio *Rendition
+	for idx, r := range p.Renditions {
+		if r.Type != "AUDIO" || r.GroupID != best.Audio || r.URI == "" {
+			continue
+		}
+		if audio == nil || (r.Default && !audio.Default) {
+			audio = &p.Renditions[idx]
+		}
+	}
+	return best, audio
+}
+
+// Duration returns the sum of all segment durations in seconds.
+func (p *MediaPlaylist) Duration() float64 {
+	total := 0.0
+	for _, s := range p.Segments {
+		total += s.Duration
+	}
+	return total
+}
+
+func resolve(base *url.URL, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid uri %q: %w", ErrInvalidPlaylist, ref, err)
+	}
+	if base == nil {
+		return u.String(), nil
+	}
+	return base.ResolveReference(u).String(), nil
+}
+
+func parseKey(base *url.URL, value string) (*Key, error) {
+	attrs := parseAttributes(value)
+	method := attrs["METHOD"]
+	switch method {
+	case MethodNone:
+		return nil, nil
+	case MethodAES128, MethodSampleAES:
+	default:
+		return nil, fmt.Errorf("%w: unknown key method: %q", ErrInvalidPlaylist, method)
+	}
+
+	uri, err := resolve(base, attrs["URI"])
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{
+		Method: method,
+		URI:    uri,
+	}
+
+	if iv := attrs["IV"]; iv != "" {
+		iv = strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X")
+		key.IV, err = hex.DecodeString(iv)
+		if err != nil || len(key.IV) != 16 {
+			return nil, fmt.Errorf("%w: invalid key iv: %q", ErrInvalidPlaylist, attrs["IV"])
+		}
+	}
+	return key, nil
+}
+
+// parseByteRange parses <length>[@<offset>], the offset is -1 in case it is missing.
+func parseByteRange(value string) (*ByteRange, error) {
+	l, o, found := strings.Cut(value, "@")
+	length, err := strconv.ParseInt(l, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid byte range: %q", ErrInvalidPlaylist, value)
+	}
+
+	br := &ByteRange{Length: length, Offset: -1}
+	if found {
+		br.Offset, err = strconv.ParseInt(o, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid byte range: %q", ErrInvalidPlaylist, value)
+		}
+	}
+	return br, nil
+}
+
+// parseAttributes parses an attribute list like BANDWIDTH=1280000,CODECS="avc1,mp4a".
+func parseAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for s != "" {
+		key, rest, found := strings.Cut(s, "=")
+		if !found {
+			break
+		}
+		key = strings.TrimSpace(key)
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+			_, rest, _ = strings.Cut(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+
+		attrs[key] = value
+		s = rest
+	}
+	return attrs
+}
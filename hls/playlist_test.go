@@ -0,0 +1,297 @@
+package hls
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestParseMaster(t *testing.T) {
+	const playlist = `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",LANGUAGE="en",NAME="English",DEFAULT=NO,URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",LANGUAGE="de",NAME="Deutsch",DEFAULT=YES,URI="audio/de.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",LANGUAGE="en",NAME="English",URI="subs/en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,RESOLUTION=640x360,CODECS="avc1.4d401e,mp4a.40.2",AUDIO="aud"
+low/index.m3u8
+
+#EXT-X-STREAM-INF:BANDWIDTH=5120000,RESOLUTION=1920x1080,CODECS="avc1.640028,mp4a.40.2",AUDIO="aud"
+https://cdn.example.com/high/index.m3u8
+`
+
+	master, media, err := Parse(strings.NewReader(playlist), mustParseURL(t, "https://example.com/stream/master.m3u8?token=abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media != nil {
+		t.Fatalf("expected a master playlist, got a media playlist")
+	}
+
+	wantVariants := []Variant{
+		{
+			URI:        "https://example.com/stream/low/index.m3u8",
+			Bandwidth:  1280000,
+			Resolution: "640x360",
+			Codecs:     "avc1.4d401e,mp4a.40.2",
+			Audio:      "aud",
+		},
+		{
+			URI:        "https://cdn.example.com/high/index.m3u8",
+			Bandwidth:  5120000,
+			Resolution: "1920x1080",
+			Codecs:     "avc1.640028,mp4a.40.2",
+			Audio:      "aud",
+		},
+	}
+	if !reflect.DeepEqual(master.Variants, wantVariants) {
+		t.Errorf("variants = %+v, expected %+v", master.Variants, wantVariants)
+	}
+
+	if len(master.Renditions) != 3 {
+		t.Fatalf("found %d renditions, expected 3", len(master.Renditions))
+	}
+	if r := master.Renditions[1]; r.Language != "de" || !r.Default || r.URI != "https://example.com/stream/audio/de.m3u8" {
+		t.Errorf("unexpected rendition %+v", r)
+	}
+
+	best, audio := master.BestVariant()
+	if best.Bandwidth != 5120000 {
+		t.Errorf("best variant has bandwidth %d, expected 5120000", best.Bandwidth)
+	}
+	if audio == nil || audio.Language != "de" {
+		t.Errorf("best audio rendition = %+v, expected the default german rendition", audio)
+	}
+}
+
+func TestBestVariantWithoutAudioGroup(t *testing.T) {
+	p := &MasterPlaylist{
+		Variants: []Variant{
+			{URI: "a", Bandwidth: 100},
+			{URI: "b", Bandwidth: 300},
+			{URI: "c", Bandwidth: 200},
+		},
+	}
+
+	best, audio := p.BestVariant()
+	if best.URI != "b" {
+		t.Errorf("best variant = %q, expected %q", best.URI, "b")
+	}
+	if audio != nil {
+		t.Errorf("expected no audio rendition, got %+v", audio)
+	}
+}
+
+func TestParseMedia(t *testing.T) {
+	const playlist = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXT-X-MEDIA-SEQUENCE:7
+#EXTINF:9.009,
+seg-7.ts
+#EXT-X-KEY:METHOD=AES-128,URI="https://keys.example.com/k1",IV=0x000102030405060708090a0b0c0d0e0f
+#EXTINF:10.0,title
+seg-8.ts
+#EXT-X-KEY:METHOD=AES-128,URI="k2"
+#EXTINF:4.5,
+/abs/seg-9.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:1,
+seg-10.ts
+#EXT-X-ENDLIST
+`
+
+	master, media, err := Parse(strings.NewReader(playlist), mustParseURL(t, "https://example.com/video/index.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if master != nil {
+		t.Fatalf("expected a media playlist, got a master playlist")
+	}
+
+	if media.TargetDuration != 10 || media.MediaSequence != 7 || !media.EndList {
+		t.Errorf("unexpected playlist header %+v", media)
+	}
+	if media.Map != nil {
+		t.Errorf("expected no initialization section, got %+v", media.Map)
+	}
+
+	iv := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	want := []Segment{
+		{URI: "https://example.com/video/seg-7.ts", Duration: 9.009, Sequence: 7},
+		{
+			URI:      "https://example.com/video/seg-8.ts",
+			Duration: 10,
+			Sequence: 8,
+			Key:      &Key{Method: MethodAES128, URI: "https://keys.example.com/k1", IV: iv},
+		},
+		{
+			URI:      "https://example.com/abs/seg-9.ts",
+			Duration: 4.5,
+			Sequence: 9,
+			Key:      &Key{Method: MethodAES128, URI: "https://example.com/video/k2"},
+		},
+		{URI: "https://example.com/video/seg-10.ts", Duration: 1, Sequence: 10},
+	}
+	if !reflect.DeepEqual(media.Segments, want) {
+		t.Errorf("segments = %+v, expected %+v", media.Segments, want)
+	}
+
+	if d := media.Duration(); d != 24.509 {
+		t.Errorf("duration = %v, expected 24.509", d)
+	}
+}
+
+func TestParseByteRanges(t *testing.T) {
+	const playlist = `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MAP:URI="main.mp4",BYTERANGE="720@0"
+#EXTINF:4,
+#EXT-X-BYTERANGE:1000@720
+main.mp4
+#EXTINF:4,
+#EXT-X-BYTERANGE:2000
+main.mp4
+#EXTINF:4,
+#EXT-X-BYTERANGE:500
+main.mp4
+#EXTINF:4,
+#EXT-X-BYTERANGE:300@10000
+main.mp4
+#EXT-X-ENDLIST
+`
+
+	_, media, err := Parse(strings.NewReader(playlist), mustParseURL(t, "https://example.com/v/index.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantMap := &Map{URI: "https://example.com/v/main.mp4", ByteRange: &ByteRange{Length: 720, Offset: 0}}
+	if !reflect.DeepEqual(media.Map, wantMap) {
+		t.Errorf("map = %+v, expected %+v", media.Map, wantMap)
+	}
+
+	// ranges without offset continue after the previous range
+	want := []ByteRange{
+		{Length: 1000, Offset: 720},
+		{Length: 2000, Offset: 1720},
+		{Length: 500, Offset: 3720},
+		{Length: 300, Offset: 10000},
+	}
+	if len(media.Segments) != len(want) {
+		t.Fatalf("found %d segments, expected %d", len(media.Segments), len(want))
+	}
+	for idx, s := range media.Segments {
+		if s.ByteRange == nil || *s.ByteRange != want[idx] {
+			t.Errorf("segment %d has byte range %+v, expected %+v", idx, s.ByteRange, want[idx])
+		}
+	}
+}
+
+func TestParseMapWithoutByteRange(t *testing.T) {
+	const playlist = `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:6,
+seg-0.m4s
+#EXT-X-ENDLIST
+`
+
+	_, media, err := Parse(strings.NewReader(playlist), mustParseURL(t, "https://example.com/v/index.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantMap := &Map{URI: "https://example.com/v/init.mp4"}
+	if !reflect.DeepEqual(media.Map, wantMap) {
+		t.Errorf("map = %+v, expected %+v", media.Map, wantMap)
+	}
+}
+
+func TestParseWithoutBase(t *testing.T) {
+	const playlist = "#EXTM3U\n#EXTINF:1,\nseg.ts\n"
+
+	_, media, err := Parse(strings.NewReader(playlist), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(media.Segments) != 1 || media.Segments[0].URI != "seg.ts" {
+		t.Errorf("unexpected segments %+v", media.Segments)
+	}
+	if media.EndList {
+		t.Errorf("expected a live playlist without #EXT-X-ENDLIST")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		playlist string
+	}{
+		{"empty", ""},
+		{"blank lines only", "\n\n"},
+		{"missing header", "#EXTINF:1,\nseg.ts\n"},
+		{"no variants or segments", "#EXTM3U\n#EXT-X-VERSION:3\n"},
+		{"invalid duration", "#EXTM3U\n#EXTINF:abc,\nseg.ts\n"},
+		{"invalid byte range", "#EXTM3U\n#EXT-X-BYTERANGE:abc\n#EXTINF:1,\nseg.ts\n"},
+		{"invalid byte range offset", "#EXTM3U\n#EXT-X-BYTERANGE:10@x\n#EXTINF:1,\nseg.ts\n"},
+		{"unknown key method", "#EXTM3U\n#EXT-X-KEY:METHOD=ROT13,URI=\"k\"\n#EXTINF:1,\nseg.ts\n"},
+		{"short iv", "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"k\",IV=0x0102\n#EXTINF:1,\nseg.ts\n"},
+		{"invalid iv", "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"k\",IV=0xzz\n#EXTINF:1,\nseg.ts\n"},
+		{"invalid map byte range", "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\",BYTERANGE=\"x\"\n#EXTINF:1,\nseg.ts\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Parse(strings.NewReader(tt.playlist), nil)
+			if !errors.Is(err, ErrInvalidPlaylist) {
+				t.Errorf("Parse() = %v, expected %v", err, ErrInvalidPlaylist)
+			}
+		})
+	}
+}
+
+func TestParseKeyIVPrefix(t *testing.T) {
+	for _, iv := range []string{"0x000102030405060708090A0B0C0D0E0F", "0X000102030405060708090a0b0c0d0e0f"} {
+		key, err := parseKey(nil, `METHOD=AES-128,URI="k",IV=`+iv)
+		if err != nil {
+			t.Fatalf("parseKey(%s): %v", iv, err)
+		}
+		if want := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}; !bytes.Equal(key.IV, want) {
+			t.Errorf("iv = %x, expected %x", key.IV, want)
+		}
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]string
+	}{
+		{"", map[string]string{}},
+		{"BANDWIDTH=1280000", map[string]string{"BANDWIDTH": "1280000"}},
+		{
+			`BANDWIDTH=1280000,CODECS="avc1,mp4a",RESOLUTION=640x360`,
+			map[string]string{"BANDWIDTH": "1280000", "CODECS": "avc1,mp4a", "RESOLUTION": "640x360"},
+		},
+		{`NAME="a=b",DEFAULT=YES`, map[string]string{"NAME": "a=b", "DEFAULT": "YES"}},
+		{`URI="unterminated`, map[string]string{"URI": "unterminated"}},
+		{`A=1, B=2`, map[string]string{"A": "1", "B": "2"}},
+	}
+
+	for _, tt := range tests {
+		if got := parseAttributes(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAttributes(%q) = %v, expected %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// S05E03, s5e3
+var identifierRegex = regexp.MustCompile(`^[sS](\d+)[eE](\d+)$`)
+
+func NewInfoCmd(root *rootContext) *cobra.Command {
+	infoContext := &infoContext{rootContext: root}
+
+	cmd := &cobra.Command{
+		Use:      "info SxxEyy",
+		Short:    "show a single episode of the local catalog",
+		Args:     cobra.ExactArgs(1),
+		PreRunE:  infoContext.PreRunE,
+		RunE:     infoContext.RunE,
+		PostRunE: infoContext.PostRunE,
+	}
+
+	return cmd
+}
+
+type infoContext struct {
+	*rootContext
+}
+
+func (c *infoContext) PreRunE(cmd *cobra.Command, args []string) error {
+	return c.Init()
+}
+
+func (c *infoContext) RunE(cmd *cobra.Command, args []string) error {
+	season, episode, err := ParseIdentifier(args[0])
+	if err != nil {
+		return err
+	}
+
+	videos, err := c.EpisodeVideos(season, episode)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for idx, v := range videos {
+		if idx > 0 {
+			fmt.Fprintln(w)
+		}
+		printVideo(w, v)
+
+		err = c.printDownload(w, v)
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// number of download attempts shown per episode
+const infoAttempts = 5
+
+// printDownload prints the download state and the latest download attempts.
+func (c *infoContext) printDownload(w io.Writer, v Video) error {
+	state, err := c.DownloadState(v.Language, v.Season, v.Episode)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	fmt.Fprintf(w, "Download:\t%s\n", state.Status)
+	if state.Path != "" {
+		fmt.Fprintf(w, "Path:\t%s\n", state.Path)
+	}
+	if state.LastError != "" {
+		fmt.Fprintf(w, "Last error:\t%s\n", state.LastError)
+	}
+
+	attempts, err := c.DownloadAttempts(v, infoAttempts)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range attempts {
+		line := fmt.Sprintf("%s #%d %s", a.StartedAt.Local().Format(time.DateTime), a.Attempt, a.Status)
+		if a.Reason != "" {
+			line += fmt.Sprintf(" (%s)", a.Reason)
+		}
+		fmt.Fprintf(w, "Attempt:\t%s\n", line)
+	}
+	return nil
+}
+
+func printVideo(w io.Writer, v Video) {
+	fmt.Fprintf(w, "Language:\t%s\n", v.Language)
+	fmt.Fprintf(w, "Title:\t%s\n", v.Title)
+	fmt.Fprintf(w, "Season:\t%d\n", v.Season)
+	fmt.Fprintf(w, "Episode:\t%d\n", v.Episode)
+	fmt.Fprintf(w, "Date:\t%s\n", v.Date.Format(time.DateOnly))
+	fmt.Fprintf(w, "Url:\t%s\n", v.Url)
+	fmt.Fprintf(w, "Image:\t%s\n", v.ImageUrl)
+	fmt.Fprintf(w, "Description:\t%s\n", v.Description)
+}
+
+// ParseIdentifier parses the SxxEyy notation of an episode.
+func ParseIdentifier(s string) (season, episode int, err error) {
+	m := identifierRegex.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, fmt.Errorf("invalid episode identifier %q, expected format S05E03", s)
+	}
+
+	season, _ = strconv.Atoi(m[1])
+	episode, _ = strconv.Atoi(m[2])
+	return season, episode, nil
+}
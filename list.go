@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/config"
+	"github.com/spf13/cobra"
+)
+
+func NewListCmd(root *rootContext) *cobra.Command {
+	listContext := &listContext{rootContext: root}
+
+	cmd := &cobra.Command{
+		Use:      "list",
+		Short:    "list episodes of the local catalog",
+		Args:     cobra.ExactArgs(0),
+		RunE:     listContext.RunE,
+		PostRunE: listContext.PostRunE,
+	}
+
+	// register flags but defer parsing and validation of the final values
+	cmd.PreRunE = listContext.PreRunE(cmd)
+	return cmd
+}
+
+type listContext struct {
+	*rootContext
+	Config *config.ListConfig
+}
+
+func (c *listContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args []string) error {
+	c.Config = &config.ListConfig{}
+
+	runParser := config.RegisterFlags(c.Config, false, cmd)
+
+	return func(cmd *cobra.Command, args []string) error {
+		err := runParser()
+		if err != nil {
+			return err
+		}
+
+		return c.Init()
+	}
+}
+
+func (c *listContext) RunE(cmd *cobra.Command, args []string) error {
+	var languages []string
+	if c.Config.Language != "" {
+		var err error
+		languages, err = ParseLanguages(c.Config.Language)
+		if err != nil {
+			return err
+		}
+	}
+
+	videos, err := c.Videos(languages, c.Config.Season, 0)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EPISODE\tLANGUAGE\tDATE\tTITLE\tURL")
+	for _, v := range videos {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.Identifier(), v.Language, v.Date.Format(time.DateOnly), v.Title, v.Url)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jxsl13/southpark-downloader/utils"
+)
+
+// Locale is a regional South Park site with its own episode urls, titles and descriptions.
+type Locale struct {
+	// ISO 639-1 language code
+	Language string
+	// ISO 639-2/B language code used for stream metadata
+	LanguageISO6392 string
+	// ISO 3166-1 alpha-2 region code
+	Region string
+	// IndexUrl may redirect to a regional host
+	IndexUrl string
+	// StartPath is the path of the first episode
+	StartPath string
+	// SeasonsPath is the path of the page that links all seasons
+	SeasonsPath string
+}
+
+var Locales = []Locale{
+	{
+		Language:        "en",
+		LanguageISO6392: "eng",
+		Region:          "US",
+		IndexUrl:        "https://www.southparkstudios.com/",
+		StartPath:       "/episodes/940f8z/south-park-cartman-gets-an-anal-probe-season-1-ep-1",
+		SeasonsPath:     "/seasons/south-park",
+	},
+	{
+		Language:        "de",
+		LanguageISO6392: "ger",
+		Region:          "DE",
+		IndexUrl:        "https://www.southpark.de/",
+		StartPath:       "/folgen/940f8z/south-park-cartman-und-die-analsonde-staffel-1-ep-1",
+		SeasonsPath:     "/staffeln/south-park",
+	},
+}
+
+// LookupLocale returns the locale of the given language.
+func LookupLocale(language string) (Locale, error) {
+	for _, l := range Locales {
+		if l.Language == language {
+			return l, nil
+		}
+	}
+
+	known := make([]string, 0, len(Locales))
+	for _, l := range Locales {
+		known = append(known, l.Language)
+	}
+	return Locale{}, fmt.Errorf("unknown language %q, must be one of %s", language, strings.Join(known, utils.ListSeparator))
+}
+
+// ParseLanguages parses a comma separated list of languages and
+// validates that every language has a known locale.
+func ParseLanguages(s string) ([]string, error) {
+	var languages []string
+	for _, lang := range strings.Split(s, utils.ListSeparator) {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang == "" {
+			continue
+		}
+
+		_, err := LookupLocale(lang)
+		if err != nil {
+			return nil, err
+		}
+
+		if slices.Contains(languages, lang) {
+			continue
+		}
+		languages = append(languages, lang)
+	}
+
+	if len(languages) == 0 {
+		return nil, fmt.Errorf("at least one language is required")
+	}
+	return languages, nil
+}
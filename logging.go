@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jxsl13/southpark-downloader/config"
+	"github.com/jxsl13/southpark-downloader/utils"
+)
+
+const (
+	maxLogSize = 10 * 1024 * 1024
+	logBackups = 5
+)
+
+// logOutput is the destination of the log records that are not written to the log file,
+// the progress display redirects it in order to print log records above the progress lines.
+type logOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (o *logOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	w := o.w
+	o.mu.Unlock()
+	return w.Write(p)
+}
+
+// RedirectLogs writes the log records to w until restore is called.
+func (c *rootContext) RedirectLogs(w io.Writer) (restore func()) {
+	if c.logOutput == nil {
+		return func() {}
+	}
+
+	c.logOutput.mu.Lock()
+	defer c.logOutput.mu.Unlock()
+
+	previous := c.logOutput.w
+	c.logOutput.w = w
+	return func() {
+		c.logOutput.mu.Lock()
+		defer c.logOutput.mu.Unlock()
+		c.logOutput.w = previous
+	}
+}
+
+// InitLogging configures the default logger.
+func (c *rootContext) InitLogging() error {
+	level, err := c.Config.Level()
+	if err != nil {
+		return err
+	}
+
+	c.logOutput = &logOutput{w: os.Stderr}
+
+	var w io.Writer = c.logOutput
+	if c.Config.LogFile {
+		c.logFile, err = utils.NewRotatingFile(c.Config.LogPath(), maxLogSize, logBackups)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		w = io.MultiWriter(w, c.logFile)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch c.Config.LogFormat {
+	case config.LogFormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		handler = slog.NewTextHandler(w, opts)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// CloseLogging closes the log file.
+func (c *rootContext) CloseLogging() error {
+	if c.logFile == nil {
+		return nil
+	}
+	return c.logFile.Close()
+}
+
+// EpisodeLog creates the log file of the child processes of a single download.
+func (c *rootContext) EpisodeLog(v Video) (*os.File, error) {
+	dir := filepath.Join(c.Config.LogDir(), "episodes")
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%s_%s.log", v.Identifier(), v.Language)
+	return os.Create(filepath.Join(dir, name))
+}
@@ -4,19 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
-	"net/url"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"regexp"
-	"runtime"
-	"strconv"
-	"sync"
-	"time"
+	"syscall"
 
-	"github.com/PuerkitoBio/goquery"
-	"github.com/gocolly/colly/v2"
 	"github.com/jxsl13/southpark-downloader/config"
 	"github.com/jxsl13/southpark-downloader/utils"
 	"github.com/spf13/cobra"
@@ -26,347 +19,170 @@ import (
 func main() {
 	err := NewRootCmd().Execute()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		slog.Error(err.Error())
 		os.Exit(1)
 	}
 }
 
 func NewRootCmd() *cobra.Command {
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	stop, ctx, cancel := notifyShutdown()
 
-	rootContext := rootContext{Ctx: ctx}
+	rootContext := &rootContext{Ctx: ctx, Stop: stop}
 
-	// cmd represents the run command
+	// cmd represents the root command
 	cmd := &cobra.Command{
 		Use:   "southpark-downloader",
 		Short: "download new southpark episodes",
-		RunE:  rootContext.RunE,
 		Args:  cobra.ExactArgs(0),
-		PostRunE: func(cmd *cobra.Command, args []string) error {
+		// errors are printed by main
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
 
 			cancel()
-			return nil
+			return rootContext.CloseLogging()
 		},
 	}
 
-	// register flags but defer parsing and validation of the final values
-	cmd.PreRunE = rootContext.PreRunE(cmd)
+	// register persistent flags but defer parsing and validation of the final values
+	rootContext.RegisterFlags(cmd)
 
-	// register flags but defer parsing and validation of the final values
-	cmd.AddCommand(NewCompletionCmd(cmd.Name()))
+	cmd.AddCommand(
+		NewScrapeCmd(rootContext),
+		NewDownloadCmd(rootContext),
+		NewMergeCmd(rootContext),
+		NewWatchCmd(rootContext),
+		NewListCmd(rootContext),
+		NewInfoCmd(rootContext),
+		NewDBCmd(rootContext),
+		NewCompletionCmd(cmd.Name()),
+	)
 	return cmd
 }
 
+// notifyShutdown returns a stop context that is canceled on the first interrupt or termination
+// signal and an abort context that is canceled on the second one.
+// Running downloads are allowed to finish in between. Further signals terminate the process.
+func notifyShutdown() (stop, abort context.Context, cancel context.CancelFunc) {
+	abort, cancelAbort := context.WithCancel(context.Background())
+	stop, cancelStop := context.WithCancel(abort)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		// restore the default behavior
+		defer signal.Stop(signals)
+
+		select {
+		case <-abort.Done():
+			return
+		case sig := <-signals:
+			slog.Warn("stopping after running work has finished, repeat to abort", "signal", sig)
+			cancelStop()
+		}
+
+		select {
+		case <-abort.Done():
+		case sig := <-signals:
+			slog.Warn("aborting", "signal", sig)
+			cancelAbort()
+		}
+	}()
+
+	return stop, abort, func() {
+		cancelStop()
+		cancelAbort()
+	}
+}
+
 type rootContext struct {
-	Ctx    context.Context
+	// canceled on the second interrupt, aborts running work
+	Ctx context.Context
+	// canceled on the first interrupt, no new work must be started
+	Stop context.Context
+
 	Config *config.Config
 	DB     *sql.DB
+	// nil unless the json output is enabled
+	Events *EventWriter
+
+	parseConfig func() error
+	logFile     *utils.RotatingFile
+	logOutput   *logOutput
 }
 
-func (c *rootContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args []string) error {
+// RegisterFlags registers the flags that are shared by all subcommands.
+func (c *rootContext) RegisterFlags(cmd *cobra.Command) {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		home = "./"
 	}
 
 	c.Config = &config.Config{
-		Reinitialize: false,
-		YouTubeDLDir: "./yt-dlp",
-		OutDir:       "./downloads",
-		ConfigDir:    filepath.Join(home, ".config", "southpark-downloader"),
-		RepoUrl:      "https://github.com/yt-dlp/yt-dlp.git",
-		Branch:       "2023.03.04",
-		MinRate:      "1M",
-
-		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36",
+		ConfigDir: filepath.Join(home, ".config", "southpark-downloader"),
+		LogLevel:  "info",
+		LogFormat: config.LogFormatText,
 	}
 
 	runParser := config.RegisterFlags(c.Config, true, cmd)
-
-	return func(cmd *cobra.Command, args []string) error {
-
+	c.parseConfig = func() error {
 		err := runParser()
 		if err != nil {
 			return err
 		}
-
-		if !utils.IsApplicationAvailable(c.Ctx, "ffmpeg") {
-			return fmt.Errorf("%w: ffmpeg", utils.ErrApplicationNotFound)
-		}
-
-		err = c.InitDB()
-		if err != nil {
-			return err
-		}
-
-		return nil
+		return c.InitLogging()
 	}
 }
 
-func (c *rootContext) PostRunE(cmd *cobra.Command, args []string) error {
-	return c.CloseDB()
-}
-
-var (
-	// /folgen/940f8z/south-park-cartman-und-die-analsonde-staffel-1-ep-1
-	// /episodes/940f8z/south-park-cartman-gets-an-anal-probe-season-1-ep-1
-	episodeUrlRegex = regexp.MustCompile(`/[a-z]+/[0-9a-z]+/south-park-[0-9a-z-]+-[a-z]+-[0-9]+-[a-z]+-[0-9]+$`)
-)
-
-func (c *rootContext) RunE(cmd *cobra.Command, args []string) (err error) {
-	err = c.CollectUrls()
+// Init parses the shared flags, configures logging and opens the catalog database.
+// It must be called from within the PreRunE of a subcommand.
+func (c *rootContext) Init() error {
+	err := c.parseConfig()
 	if err != nil {
-		return fmt.Errorf("failed to collect urls: %w", err)
+		return err
 	}
 
-	return c.Download(c.Config.Season, c.Config.Episode)
+	return c.InitDB()
 }
 
-func (c *rootContext) CollectUrls() error {
-	var (
-		ctx       = c.Ctx
-		userAgent = c.Config.UserAgent
-	)
+func (c *rootContext) PostRunE(cmd *cobra.Command, args []string) error {
+	return c.CloseDB()
+}
 
-	startUrl, err := c.Last()
-	if err != nil {
-		if !errors.Is(err, ErrNotFound) {
-			return err
-		}
-		startUrl, err = StartingUrl(ctx)
-		if err != nil {
-			return err
-		}
+// Videos returns the selected episodes of all given languages.
+// No languages select all languages.
+func (c *rootContext) Videos(languages []string, season, episode int) ([]Video, error) {
+	if len(languages) == 0 {
+		languages = []string{""}
 	}
 
-	co := NewCollector(ctx, userAgent)
-
-	co.OnScraped(func(r *colly.Response) {
-		if len(r.Body) == 0 {
-			r.Request.Visit(r.Request.URL.String())
-		}
-	})
-
-	// prevent skipping first request
-	skippable := false
-	co.OnRequest(func(r *colly.Request) {
-		visited, _ := c.Visited(r.URL.String())
-		if !skippable {
-			skippable = true
-		} else if visited {
-			fmt.Println("Skipping:", r.URL.String())
-			r.Abort()
-			return
-		}
-		fmt.Println("Getting:", r.URL.String())
-	})
-
-	co.OnResponse(func(r *colly.Response) {
-		fmt.Println("Got:", r.Request.URL.String())
-
-	})
-
-	co.OnHTML("html", func(e *colly.HTMLElement) {
-		url := e.Request.URL.String()
-		meta := e.DOM.Find("meta[property]")
-
-		var (
-			title         string
-			seasonNumber  int
-			episodeNumber int
-			description   string
-			imageUrl      string
-			contentDate   time.Time
-		)
-
-		cnt := 0
-		meta.Each(func(i int, s *goquery.Selection) {
-			val, _ := s.Attr("property")
-			switch val {
-			case "search:episodeTitle":
-				title, _ = s.Attr("content")
-				cnt++
-			case "search:seasonNumber":
-				seasonNumber, _ = strconv.Atoi(s.AttrOr("content", "0"))
-				cnt++
-			case "search:episodeNumber":
-				episodeNumber, _ = strconv.Atoi(s.AttrOr("content", "0"))
-				cnt++
-			case "og:description":
-				description, _ = s.Attr("content")
-				cnt++
-			case "og:image":
-				imageUrl, _ = s.Attr("content")
-				cnt++
-			case "og:video:release_date":
-				const contentLayout = "2006-01-02T15:04:05.000Z"
-				contentDate, _ = time.Parse(contentLayout, s.AttrOr("content", "1970-01-01T00:00:00.000Z"))
-				cnt++
-			}
-		})
-
-		if cnt < 6 {
-			fmt.Fprintf(os.Stderr, "failed to parse meta tags: %v\n", meta)
-			return
-		}
-
-		err = c.Insert(
-			title,
-			seasonNumber,
-			episodeNumber,
-			url,
-			description,
-			imageUrl,
-			contentDate,
-		)
+	var videos []Video
+	for _, language := range languages {
+		vs, err := c.videos(language, season, episode)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to insert episode: %v\n", err)
-			e.Request.Abort()
-			return
-		}
-
-	})
-
-	co.OnHTML("a[href]", func(e *colly.HTMLElement) {
-		link := e.Attr("href")
-
-		if e.Request.URL.Path == link {
-			return
-		}
-
-		if e.Request.URL.String() == link {
-			return
-		}
-
-		// broken links
-		_, err := url.Parse(link)
-		if err != nil {
-			return
-		}
-
-		if episodeUrlRegex.MatchString(link) {
-			visited, err := c.Visited(link)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to check if episode was visited: %v\n", err)
-				e.Request.Abort()
-				return
-			}
-
-			if !visited {
-				e.Request.Visit(link)
+			if errors.Is(err, ErrNotFound) {
+				continue
 			}
+			return nil, err
 		}
-	})
-
-	err = co.Visit(startUrl)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (c *rootContext) Download(season, episode int) error {
-	videos, err := c.Videos(season, episode)
-	if err != nil {
-		return err
+		videos = append(videos, vs...)
 	}
 
-	concurrency := make(chan struct{}, runtime.NumCPU()/2)
-	var wg sync.WaitGroup
-	wg.Add(len(videos))
-	errs := make(chan error, len(videos))
-
-	start := time.Now()
-	for _, v := range videos {
-		go func(v Video) {
-			defer wg.Done()
-			concurrency <- struct{}{}
-
-			err := c.DownloadVideo(v)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to download video: %v\n", err)
-				errs <- err
-			}
-		}(v)
+	if len(videos) == 0 {
+		return nil, ErrNotFound
 	}
-
-	wg.Wait()
-
-	dur := time.Since(start)
-	fmt.Printf("Downloaded %d videos in %s\n", len(videos), dur)
-
-	errList := make([]error, 0, len(errs))
-
-loop:
-	for i := 0; i < len(videos); i++ {
-		select {
-		case err := <-errs:
-			errList = append(errList, err)
-		default:
-			break loop
-		}
-	}
-
-	return errors.Join(errList...)
+	return videos, nil
 }
 
-func (c *rootContext) Videos(season, episode int) ([]Video, error) {
+func (c *rootContext) videos(language string, season, episode int) ([]Video, error) {
 	if season == 0 && episode == 0 {
-		return c.All()
+		return c.All(language)
 	}
 
 	if episode == 0 {
-		return c.Season(season)
-	}
-
-	v, err := c.Episode(season, episode)
-	if err != nil {
-		return nil, err
-	}
-	return []Video{v}, nil
-}
-
-func (c *rootContext) DownloadVideo(v Video) (err error) {
-
-	outDir := filepath.Join(c.Config.OutDir, v.SeasonString())
-	err = os.MkdirAll(outDir, 0755)
-	if err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
-	}
-
-	exe := "yt-dlp"
-	if runtime.GOOS == "windows" {
-		exe += ".cmd"
-	} else {
-		exe += ".sh"
-	}
-
-	cmd := filepath.Join(c.Config.YouTubeDLDir, exe)
-	absCmd, err := filepath.Abs(cmd)
-	if err != nil {
-		return err
-	}
-
-	if c.Config.DryRun {
-		fmt.Println("Would download:", v.Url)
-		return nil
+		return c.Season(language, season)
 	}
 
-	err = utils.ExecutePathApplication(
-		c.Ctx,
-		outDir,
-		absCmd,
-		"--concurrent-fragments",
-		strconv.Itoa(runtime.NumCPU()/2),
-		"--throttled-rate",
-		c.Config.MinRate,
-		"--output",
-		v.Format(),
-		v.Url,
-	)
-	if err != nil {
-		return err
-	}
-	return nil
+	return c.queryVideos(episodeVideos, language, season, episode)
 }
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/config"
+	"github.com/jxsl13/southpark-downloader/utils"
+	"github.com/spf13/cobra"
+)
+
+// ErrDurationMismatch is returned for episodes whose languages have different cuts.
+var ErrDurationMismatch = errors.New("duration mismatch")
+
+// convertedSubtitles are text subtitle codecs that matroska cannot store, e.g. the subtitles
+// of mp4 files. They are converted to srt, all other subtitles are copied.
+var convertedSubtitles = map[string]bool{
+	"mov_text": true,
+	"text":     true,
+}
+
+func NewMergeCmd(root *rootContext) *cobra.Command {
+	mergeContext := &mergeContext{rootContext: root}
+
+	cmd := &cobra.Command{
+		Use:      "merge",
+		Short:    "merge the audio tracks of downloaded languages into a single file",
+		Args:     cobra.ExactArgs(0),
+		RunE:     mergeContext.RunE,
+		PostRunE: mergeContext.PostRunE,
+	}
+
+	// register flags but defer parsing and validation of the final values
+	cmd.PreRunE = mergeContext.PreRunE(cmd)
+	return cmd
+}
+
+type mergeContext struct {
+	*rootContext
+	Config    *config.MergeConfig
+	Selection *config.SelectionConfig
+	Paths     *PathTemplate
+	Languages []string
+}
+
+func (c *mergeContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args []string) error {
+	c.Config = &config.MergeConfig{
+		OutDir:          "./downloads",
+		Language:        "de,en",
+		MaxDurationDiff: 2 * time.Second,
+		DirTemplate:     DefaultDirTemplate,
+		FileTemplate:    DefaultFileTemplate,
+	}
+
+	c.Selection = &config.SelectionConfig{}
+
+	runSelectionParser := config.RegisterFlags(c.Selection, false, cmd)
+	runParser := config.RegisterFlags(c.Config, false, cmd)
+
+	return func(cmd *cobra.Command, args []string) error {
+		err := runSelectionParser()
+		if err != nil {
+			return err
+		}
+
+		err = runParser()
+		if err != nil {
+			return err
+		}
+
+		c.Languages, err = ParseLanguages(c.Config.Language)
+		if err != nil {
+			return err
+		}
+
+		if len(c.Languages) < 2 {
+			return fmt.Errorf("at least two languages are required in order to merge them")
+		}
+
+		if c.Config.DefaultAudio == "" {
+			c.Config.DefaultAudio = c.Languages[0]
+		}
+
+		if !slices.Contains(c.Languages, c.Config.DefaultAudio) {
+			return fmt.Errorf("default audio language %q must be one of the merged languages", c.Config.DefaultAudio)
+		}
+
+		c.Paths, err = NewPathTemplate(c.Config.DirTemplate, c.Config.FileTemplate)
+		if err != nil {
+			return err
+		}
+
+		if !c.Paths.UsesLanguage() {
+			return fmt.Errorf("the dir or file template must contain {{.Language}} in order to not overwrite downloaded files")
+		}
+
+		for _, app := range []string{"ffmpeg", "ffprobe"} {
+			if !utils.IsApplicationAvailable(c.Ctx, app) {
+				return fmt.Errorf("%w: %s", utils.ErrApplicationNotFound, app)
+			}
+		}
+
+		return c.Init()
+	}
+}
+
+func (c *mergeContext) RunE(cmd *cobra.Command, args []string) error {
+	// the first language provides the video stream
+	videos, err := c.Videos(c.Languages[:1], c.Selection.Season, c.Selection.Episode)
+	if err != nil {
+		return err
+	}
+
+	SortVideos(videos, config.OrderAsc)
+
+	var (
+		merged  int
+		skipped int
+		errList = make([]error, 0, len(videos))
+	)
+
+	for _, v := range videos {
+		// the running merge finishes after the first interrupt
+		if c.Stop.Err() != nil {
+			break
+		}
+
+		err := c.MergeEpisode(c.Ctx, v)
+		switch {
+		case err == nil:
+			merged++
+		case errors.Is(err, ErrSkipped):
+			skipped++
+			slog.Info("skipping video", "episode", v.Identifier(), "reason", err)
+		default:
+			slog.Error("failed to merge video", "episode", v.Identifier(), "error", err)
+			err = fmt.Errorf("%s: %w", v.Identifier(), err)
+			errList = append(errList, err)
+		}
+	}
+
+	slog.Info("merged videos",
+		"merged", merged,
+		"total", len(videos),
+		"skipped", skipped,
+		"failed", len(errList),
+	)
+	return errors.Join(errList...)
+}
+
+// mergeInput is a downloaded language of an episode.
+type mergeInput struct {
+	Language        string
+	LanguageISO6392 string
+	Path            string
+	Audio           int
+	// codecs of the subtitle streams
+	Subtitles []string
+}
+
+// MergeEpisode muxes the audio and subtitle tracks of all languages into the video of the primary language.
+// The merged file is named after all languages joined by +, e.g. de+en.
+func (c *mergeContext) MergeEpisode(ctx context.Context, primary Video) (err error) {
+	merged := primary
+	merged.Language = strings.Join(c.Languages, "+")
+
+	m := Merge{
+		Languages: c.Languages,
+		Season:    primary.Season,
+		Episode:   primary.Episode,
+	}
+
+	if !c.Config.Force {
+		_, done, err := c.Merged(m)
+		if err != nil {
+			return err
+		}
+
+		if done {
+			return fmt.Errorf("%w: already merged", ErrSkipped)
+		}
+	}
+
+	inputs, err := c.mergeInputs(ctx, primary)
+	if err != nil {
+		return err
+	}
+
+	dir, err := c.Paths.Dir(merged)
+	if err != nil {
+		return err
+	}
+
+	fileName, err := c.Paths.FileName(merged)
+	if err != nil {
+		return err
+	}
+
+	outDir := filepath.Join(c.Config.OutDir, filepath.FromSlash(dir))
+	outPath, err := filepath.Abs(filepath.Join(outDir, fileName+".mkv"))
+	if err != nil {
+		return err
+	}
+
+	if c.Config.DryRun {
+		slog.Info("would merge video", "episode", primary.Identifier(), "path", outPath)
+		return fmt.Errorf("%w: dry run", ErrSkipped)
+	}
+
+	err = os.MkdirAll(outDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	err = c.MarkMergeRunning(m)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, c.MarkMergeError(m, err))
+		}
+	}()
+
+	// hidden files are not picked up by media servers
+	tmpPath := filepath.Join(outDir, "."+fileName+".merge.mkv")
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	_, err = utils.ExecuteQuietPathApplicationWithOutput(ctx, outDir, "ffmpeg", c.mergeArgs(primary, inputs, tmpPath)...)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, outPath)
+	if err != nil {
+		return err
+	}
+
+	sum, size, err := utils.FileSHA256(outPath)
+	if err != nil {
+		return err
+	}
+
+	return c.MarkMergeDone(m, outPath, size, sum)
+}
+
+// mergeInputs collects the downloaded files of all languages and rejects
+// episodes whose durations differ too much, as their audio would be out of sync.
+func (c *mergeContext) mergeInputs(ctx context.Context, primary Video) ([]mergeInput, error) {
+	var (
+		inputs    = make([]mergeInput, 0, len(c.Languages))
+		durations = make([]time.Duration, 0, len(c.Languages))
+	)
+
+	for _, language := range c.Languages {
+		l, err := LookupLocale(language)
+		if err != nil {
+			return nil, err
+		}
+
+		v := primary
+		v.Language = language
+
+		path, done, err := c.Downloaded(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if !done {
+			return nil, fmt.Errorf("%w: language %s has not been downloaded", ErrSkipped, language)
+		}
+
+		d, err := ProbeDuration(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(durations) > 0 {
+			diff := d - durations[0]
+			if diff < 0 {
+				diff = -diff
+			}
+
+			if diff > c.Config.MaxDurationDiff {
+				return nil, fmt.Errorf("%w: %s (%s) and %s (%s) differ by %s",
+					ErrDurationMismatch,
+					inputs[0].Language,
+					durations[0],
+					language,
+					d,
+					diff,
+				)
+			}
+		}
+
+		streams, err := ProbeStreams(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+
+		in := mergeInput{
+			Language:        language,
+			LanguageISO6392: l.LanguageISO6392,
+			Path:            path,
+		}
+		for _, s := range streams {
+			switch s.CodecType {
+			case "audio":
+				in.Audio++
+			case "subtitle":
+				in.Subtitles = append(in.Subtitles, s.CodecName)
+			}
+		}
+
+		if in.Audio == 0 {
+			return nil, fmt.Errorf("%s of language %s does not contain any audio stream", path, language)
+		}
+
+		durations = append(durations, d)
+		inputs = append(inputs, in)
+	}
+
+	return inputs, nil
+}
+
+func (c *mergeContext) mergeArgs(primary Video, inputs []mergeInput, outPath string) []string {
+	args := []string{
+		"-y",
+		"-loglevel", "error",
+	}
+
+	for _, in := range inputs {
+		args = append(args, "-i", in.Path)
+	}
+
+	// V excludes attached pictures, e.g. embedded cover art
+	args = append(args, "-map", "0:V")
+
+	for idx := range inputs {
+		args = append(args, "-map", fmt.Sprintf("%d:a", idx))
+	}
+	for idx, in := range inputs {
+		if len(in.Subtitles) > 0 {
+			args = append(args, "-map", fmt.Sprintf("%d:s", idx))
+		}
+	}
+
+	args = append(args, "-c", "copy")
+
+	var (
+		audioIdx    int
+		subtitleIdx int
+		hasDefault  bool
+	)
+	for _, in := range inputs {
+		for i := 0; i < in.Audio; i++ {
+			disposition := "0"
+			if !hasDefault && in.Language == c.Config.DefaultAudio {
+				disposition = "default"
+				hasDefault = true
+			}
+
+			args = append(args,
+				fmt.Sprintf("-metadata:s:a:%d", audioIdx), "language="+in.LanguageISO6392,
+				fmt.Sprintf("-disposition:a:%d", audioIdx), disposition,
+			)
+			audioIdx++
+		}
+
+		for _, codec := range in.Subtitles {
+			if convertedSubtitles[codec] {
+				args = append(args, fmt.Sprintf("-c:s:%d", subtitleIdx), "srt")
+			}
+			args = append(args,
+				fmt.Sprintf("-metadata:s:s:%d", subtitleIdx), "language="+in.LanguageISO6392,
+				fmt.Sprintf("-disposition:s:%d", subtitleIdx), "0",
+			)
+			subtitleIdx++
+		}
+	}
+
+	args = append(args,
+		"-metadata", "title="+primary.Title,
+		outPath,
+	)
+	return args
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/config"
+)
+
+// ffprobeStub reports the same duration and streams for every file.
+const ffprobeStub = `#!/bin/sh
+case "$*" in
+*format=duration*)
+	echo 1320.5
+	;;
+*)
+	echo '{"streams": [{"codec_type": "video", "codec_name": "h264"}, {"codec_type": "audio", "codec_name": "aac"}]}'
+	;;
+esac
+`
+
+// newTestMergeContext returns a merge context of german and english whose catalog contains
+// the downloaded test videos of both languages. ffprobe is replaced by a stub.
+func newTestMergeContext(t *testing.T, videos []Video) *mergeContext {
+	t.Helper()
+
+	bin := t.TempDir()
+	err := os.WriteFile(filepath.Join(bin, "ffprobe"), []byte(ffprobeStub), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+
+	dir := t.TempDir()
+	root := &rootContext{
+		Ctx:    context.Background(),
+		Stop:   context.Background(),
+		Config: &config.Config{ConfigDir: dir},
+	}
+
+	err = root.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = root.CloseDB() })
+
+	paths, err := NewPathTemplate(DefaultDirTemplate, DefaultFileTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	languages := []string{"de", "en"}
+	for _, v := range videos {
+		for _, language := range languages {
+			v.Language = language
+			err = root.Insert(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(dir, v.Identifier()+"_"+language+".mp4")
+			err = os.WriteFile(path, []byte("video"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = root.MarkRunning(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = root.MarkDone(v, path, 5, "sum")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	return &mergeContext{
+		rootContext: root,
+		Config: &config.MergeConfig{
+			OutDir:          filepath.Join(dir, "merged"),
+			Language:        "de,en",
+			DefaultAudio:    "de",
+			MaxDurationDiff: 2 * time.Second,
+			DirTemplate:     DefaultDirTemplate,
+			FileTemplate:    DefaultFileTemplate,
+		},
+		Selection: &config.SelectionConfig{},
+		Paths:     paths,
+		Languages: languages,
+	}
+}
+
+func TestMergeArgsSubtitleCodecs(t *testing.T) {
+	c := &mergeContext{Config: &config.MergeConfig{DefaultAudio: "de"}}
+	inputs := []mergeInput{
+		{Language: "de", LanguageISO6392: "ger", Path: "de.mkv", Audio: 1, Subtitles: []string{"hdmv_pgs_subtitle"}},
+		{Language: "en", LanguageISO6392: "eng", Path: "en.mp4", Audio: 1, Subtitles: []string{"mov_text", "dvb_subtitle"}},
+	}
+
+	args := c.mergeArgs(Video{Title: "Pilot"}, inputs, "out.mkv")
+
+	if !containsSeq(args, "-c", "copy") {
+		t.Errorf("streams are not copied: %v", args)
+	}
+	if !containsSeq(args, "-c:s:1", "srt") {
+		t.Errorf("mp4 subtitles are not converted to srt: %v", args)
+	}
+	for _, arg := range []string{"-c:s", "-c:s:0", "-c:s:2"} {
+		if slices.Contains(args, arg) {
+			t.Errorf("bitmap subtitles must be copied, found %s: %v", arg, args)
+		}
+	}
+	for _, seq := range [][]string{{"-map", "0:s"}, {"-map", "1:s"}, {"-metadata:s:s:2", "language=eng"}} {
+		if !containsSeq(args, seq...) {
+			t.Errorf("missing %v: %v", seq, args)
+		}
+	}
+}
+
+func TestMergeArgsWithoutSubtitles(t *testing.T) {
+	c := &mergeContext{Config: &config.MergeConfig{DefaultAudio: "en"}}
+	inputs := []mergeInput{
+		{Language: "de", LanguageISO6392: "ger", Path: "de.mkv", Audio: 1},
+		{Language: "en", LanguageISO6392: "eng", Path: "en.mkv", Audio: 1},
+	}
+
+	args := c.mergeArgs(Video{Title: "Pilot"}, inputs, "out.mkv")
+
+	if containsSeq(args, "-map", "0:s") || containsSeq(args, "-map", "1:s") {
+		t.Errorf("subtitles are mapped without subtitle streams: %v", args)
+	}
+	if !containsSeq(args, "-disposition:a:0", "0") || !containsSeq(args, "-disposition:a:1", "default") {
+		t.Errorf("english audio is not the default: %v", args)
+	}
+}
+
+func TestMergeState(t *testing.T) {
+	c := newTestDownloadContext(t, nil)
+	m := Merge{Languages: []string{"de", "en"}, Season: 1, Episode: 1}
+
+	err := c.MarkMergeRunning(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.MarkMergeError(m, errors.New("ffmpeg failed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, merged, err := c.Merged(m)
+	if err != nil || merged {
+		t.Fatalf("failed merge is merged: %v, %v", merged, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "merged.mkv")
+	err = os.WriteFile(path, []byte("video"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.MarkMergeRunning(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.MarkMergeDone(m, path, 5, "sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, merged, err := c.Merged(m)
+	if err != nil || !merged || got != path {
+		t.Fatalf("expected merged file %s, got %s, %v, %v", path, got, merged, err)
+	}
+
+	// the merge of the languages in a different order is a different file
+	_, merged, err = c.Merged(Merge{Languages: []string{"en", "de"}, Season: 1, Episode: 1})
+	if err != nil || merged {
+		t.Fatalf("merge with a different primary language is merged: %v, %v", merged, err)
+	}
+
+	// merges do not show up as downloads
+	for _, language := range []string{"de", "en", "de+en"} {
+		_, err = c.DownloadState(language, 1, 1)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected no download state of %s, got %v", language, err)
+		}
+	}
+}
+
+func TestMergeDryRun(t *testing.T) {
+	videos := testVideos(2)
+	c := newTestMergeContext(t, videos)
+	c.Config.DryRun = true
+
+	for _, v := range videos {
+		v.Language = "de"
+		err := c.MergeEpisode(context.Background(), v)
+		if !errors.Is(err, ErrSkipped) {
+			t.Errorf("%s: %v, expected %v", v.Label(), err, ErrSkipped)
+		}
+	}
+
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	err := c.RunE(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the summary counts dry runs as skipped
+	if !strings.Contains(logs.String(), "merged=0 total=2 skipped=2 failed=0") {
+		t.Errorf("unexpected summary, logs:\n%s", logs.String())
+	}
+
+	_, err = os.Stat(c.Config.OutDir)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a dry run not to create the output directory: %v", err)
+	}
+
+	for _, v := range videos {
+		_, merged, err := c.Merged(Merge{Languages: c.Languages, Season: v.Season, Episode: v.Episode})
+		if err != nil || merged {
+			t.Errorf("%s is merged after a dry run: %v, %v", v.Label(), merged, err)
+		}
+	}
+}
+
+// containsSeq reports whether args contain seq as consecutive elements.
+func containsSeq(args []string, seq ...string) bool {
+	for idx := 0; idx+len(seq) <= len(args); idx++ {
+		if slices.Equal(args[idx:idx+len(seq)], seq) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/utils"
+)
+
+// EmbedMetadata remuxes the video file in place without re-encoding and adds the
+// catalog metadata as well as the episode image as cover art.
+func (c *downloadContext) EmbedMetadata(ctx context.Context, v Video, videoPath string) (err error) {
+	var (
+		dir  = filepath.Dir(videoPath)
+		ext  = filepath.Ext(videoPath)
+		name = strings.TrimSuffix(filepath.Base(videoPath), ext)
+		// hidden files are neither picked up by media servers nor by findDownload
+		tmpPath = filepath.Join(dir, "."+name+".metadata"+ext)
+	)
+
+	coverPath, cleanup, err := c.coverImage(ctx, v, videoPath)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	args := []string{
+		"-y",
+		"-loglevel", "error",
+		"-i", videoPath,
+	}
+
+	switch strings.ToLower(ext) {
+	case ".mkv", ".webm":
+		args = append(args,
+			"-map", "0",
+			"-attach", coverPath,
+			"-metadata:s:t", "mimetype=image/jpeg",
+			"-metadata:s:t", "filename=cover.jpg",
+		)
+	default:
+		args = append(args,
+			"-i", coverPath,
+			"-map", "0",
+			"-map", "1",
+			"-disposition:v:1", "attached_pic",
+		)
+	}
+
+	args = append(args, "-c", "copy")
+
+	for _, kv := range [][2]string{
+		{"title", v.Title},
+		{"description", v.Description},
+		{"synopsis", v.Description},
+		{"show", showTitle},
+		{"season_number", strconv.Itoa(v.Season)},
+		{"episode_sort", strconv.Itoa(v.Episode)},
+		{"episode_id", v.Identifier()},
+		{"date", v.Date.Format(time.DateOnly)},
+		{"network", showStudio},
+	} {
+		args = append(args, "-metadata", kv[0]+"="+kv[1])
+	}
+
+	args = append(args, tmpPath)
+
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	_, err = utils.ExecuteQuietPathApplicationWithOutput(ctx, dir, "ffmpeg", args...)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, videoPath)
+}
+
+// coverImage returns the thumbnail written by WriteSidecars or downloads the image
+// into a temporary file that is removed by calling cleanup.
+func (c *downloadContext) coverImage(ctx context.Context, v Video, videoPath string) (coverPath string, cleanup func(), err error) {
+	var (
+		dir  = filepath.Dir(videoPath)
+		base = strings.TrimSuffix(videoPath, filepath.Ext(videoPath))
+	)
+
+	_, coverPath = sidecarPaths(videoPath)
+	found, err := utils.ExistsFile(coverPath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if found {
+		return coverPath, func() {}, nil
+	}
+
+	coverPath = filepath.Join(dir, "."+filepath.Base(base)+".cover.jpg")
+	err = DownloadImage(ctx, c.transport, v.ImageUrl, coverPath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return coverPath, func() { _ = os.Remove(coverPath) }, nil
+}
+
+// embedMetadata is a best effort operation that must not fail the download.
+func (c *downloadContext) embedMetadata(ctx context.Context, v Video, videoPath string) {
+	if !c.Config.EmbedMetadata {
+		return
+	}
+
+	err := c.EmbedMetadata(ctx, v, videoPath)
+	if err != nil {
+		slog.Warn("failed to embed metadata", "episode", v.Label(), "error", err)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Migration is a single, versioned step of the catalog schema.
+// Migrations must never be changed once released, add a new one instead.
+type Migration struct {
+	Version     int
+	Description string
+	SQL         string
+}
+
+// migrations must be ordered by version without any gaps, starting at 1.
+// The first migrations use IF NOT EXISTS, as databases created before the
+// introduction of migrations already contain their tables with user_version 0.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create southpark catalog table",
+		SQL: `
+CREATE TABLE IF NOT EXISTS southpark (
+	season INTEGER,
+	episode INTEGER,
+	title TEXT,
+	url TEXT,
+	description TEXT,
+	imageUrl TEXT,
+	date TEXT,
+	PRIMARY KEY (season, episode)
+);
+
+CREATE INDEX IF NOT EXISTS idx_southpark_url ON southpark (url);
+`,
+	},
+	{
+		Version:     2,
+		Description: "create downloads table",
+		SQL: `
+CREATE TABLE IF NOT EXISTS downloads (
+	season INTEGER,
+	episode INTEGER,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	lastError TEXT NOT NULL DEFAULT '',
+	path TEXT NOT NULL DEFAULT '',
+	size INTEGER NOT NULL DEFAULT 0,
+	sha256 TEXT NOT NULL DEFAULT '',
+	createdAt TEXT,
+	updatedAt TEXT,
+	PRIMARY KEY (season, episode)
+);
+`,
+	},
+	{
+		Version:     3,
+		Description: "add language to the catalog and downloads tables",
+		SQL: `
+CREATE TABLE southpark_v3 (
+	language TEXT NOT NULL,
+	season INTEGER,
+	episode INTEGER,
+	title TEXT,
+	url TEXT,
+	description TEXT,
+	imageUrl TEXT,
+	date TEXT,
+	PRIMARY KEY (language, season, episode)
+);
+
+INSERT INTO southpark_v3 (language, season, episode, title, url, description, imageUrl, date)
+SELECT
+	CASE WHEN url LIKE '%/folgen/%' THEN 'de' ELSE 'en' END,
+	season, episode, title, url, description, imageUrl, date
+FROM southpark;
+
+DROP TABLE southpark;
+ALTER TABLE southpark_v3 RENAME TO southpark;
+CREATE INDEX idx_southpark_url ON southpark (url);
+
+CREATE TABLE downloads_v3 (
+	language TEXT NOT NULL,
+	season INTEGER,
+	episode INTEGER,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	lastError TEXT NOT NULL DEFAULT '',
+	path TEXT NOT NULL DEFAULT '',
+	size INTEGER NOT NULL DEFAULT 0,
+	sha256 TEXT NOT NULL DEFAULT '',
+	createdAt TEXT,
+	updatedAt TEXT,
+	PRIMARY KEY (language, season, episode)
+);
+
+INSERT INTO downloads_v3 (language, season, episode, status, attempts, lastError, path, size, sha256, createdAt, updatedAt)
+SELECT
+	COALESCE((SELECT s.language FROM southpark s WHERE s.season = d.season AND s.episode = d.episode), 'en'),
+	d.season, d.episode, d.status, d.attempts, d.lastError, d.path, d.size, d.sha256, d.createdAt, d.updatedAt
+FROM downloads d;
+
+DROP TABLE downloads;
+ALTER TABLE downloads_v3 RENAME TO downloads;
+`,
+	},
+	{
+		Version:     4,
+		Description: "create download attempts table",
+		SQL: `
+CREATE TABLE download_attempts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	language TEXT NOT NULL,
+	season INTEGER,
+	episode INTEGER,
+	attempt INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	startedAt TEXT,
+	finishedAt TEXT
+);
+
+CREATE INDEX idx_download_attempts_episode ON download_attempts (language, season, episode);
+`,
+	},
+	{
+		Version:     5,
+		Description: "create pages table",
+		SQL: `
+CREATE TABLE pages (
+	language TEXT NOT NULL,
+	season INTEGER,
+	episode INTEGER,
+	url TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	createdAt TEXT,
+	updatedAt TEXT,
+	PRIMARY KEY (language, season, episode)
+);
+`,
+	},
+	{
+		Version:     6,
+		Description: "create merges table",
+		SQL: `
+CREATE TABLE merges (
+	languages TEXT NOT NULL,
+	season INTEGER,
+	episode INTEGER,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	lastError TEXT NOT NULL DEFAULT '',
+	path TEXT NOT NULL DEFAULT '',
+	size INTEGER NOT NULL DEFAULT 0,
+	sha256 TEXT NOT NULL DEFAULT '',
+	createdAt TEXT,
+	updatedAt TEXT,
+	PRIMARY KEY (languages, season, episode)
+);
+`,
+	},
+}
+
+// SchemaVersion returns the migration version the catalog database is at.
+func (c *rootContext) SchemaVersion() (int, error) {
+	var version int
+	err := c.DB.QueryRowContext(c.Ctx, "PRAGMA user_version;").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+	return version, nil
+}
+
+// PendingMigrations returns all migrations that have not been applied yet.
+func (c *rootContext) PendingMigrations() ([]Migration, error) {
+	version, err := c.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	latest := migrations[len(migrations)-1].Version
+	if version > latest {
+		return nil, fmt.Errorf("database schema version %d is newer than the latest supported version %d", version, latest)
+	}
+
+	return migrations[version:], nil
+}
+
+// Migrate applies all pending migrations in order, each within its own transaction.
+// In dry run mode the pending migrations are only returned.
+func (c *rootContext) Migrate(dryRun bool) ([]Migration, error) {
+	pending, err := c.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return pending, nil
+	}
+
+	for _, m := range pending {
+		err := c.migrate(m)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("applied migration", "version", m.Version, "description", m.Description)
+	}
+
+	return pending, nil
+}
+
+func (c *rootContext) migrate(m Migration) (err error) {
+	tx, err := c.DB.BeginTx(c.Ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			err = fmt.Errorf("failed to apply migration %d (%s): %w", m.Version, m.Description, err)
+		}
+	}()
+
+	_, err = tx.ExecContext(c.Ctx, m.SQL)
+	if err != nil {
+		return err
+	}
+
+	// PRAGMA does not support placeholders
+	_, err = tx.ExecContext(c.Ctx, fmt.Sprintf("PRAGMA user_version = %d;", m.Version))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func init() {
+	for idx, m := range migrations {
+		if m.Version != idx+1 {
+			panic(fmt.Sprintf("migration %d (%s) is out of order, expected version %d", m.Version, m.Description, idx+1))
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/utils"
+)
+
+const (
+	DefaultDirTemplate = `S{{printf "%02d" .Season}}`
+	// english file names are kept without suffix for backwards compatibility
+	DefaultFileTemplate = `South_Park_S{{printf "%02d" .Season}}E{{printf "%02d" .Episode}}{{if ne .Language "en"}}_{{.Language}}{{end}}`
+
+	// yt-dlp appends the extension as well as temporary suffixes like
+	// .f137.mp4.part-Frag123 to the file name, so we leave some room.
+	maxFileNameLen = 200
+)
+
+// PathData is passed to the directory and file name templates.
+type PathData struct {
+	Title      string
+	Season     int
+	Episode    int
+	Identifier string
+	Date       time.Time
+	Language   string
+}
+
+// PathTemplate renders the location of a downloaded video.
+type PathTemplate struct {
+	dir  *template.Template
+	file *template.Template
+}
+
+// UsesLanguage returns true in case the rendered paths depend on the language,
+// which is required to download multiple languages without overwriting files.
+func (t *PathTemplate) UsesLanguage() bool {
+	for _, tmpl := range []*template.Template{t.dir, t.file} {
+		a, errA := execute(tmpl, Video{Season: 1, Episode: 1, Language: "aa"})
+		b, errB := execute(tmpl, Video{Season: 1, Episode: 1, Language: "bb"})
+		if errA == nil && errB == nil && a != b {
+			return true
+		}
+	}
+	return false
+}
+
+func NewPathTemplate(dirTemplate, fileTemplate string) (*PathTemplate, error) {
+	dir, err := template.New("dir").Option("missingkey=error").Parse(dirTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory template: %w", err)
+	}
+
+	file, err := template.New("file").Option("missingkey=error").Parse(fileTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file template: %w", err)
+	}
+
+	return &PathTemplate{
+		dir:  dir,
+		file: file,
+	}, nil
+}
+
+// Dir returns the directory of the video relative to the output directory.
+// Slashes in the template separate nested directories.
+func (t *PathTemplate) Dir(v Video) (string, error) {
+	s, err := execute(t.dir, v)
+	if err != nil {
+		return "", err
+	}
+
+	parts := strings.Split(s, "/")
+	segments := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = utils.SanitizeFileName(p, maxFileNameLen)
+		if p == "" {
+			continue
+		}
+		segments = append(segments, p)
+	}
+
+	return path.Join(segments...), nil
+}
+
+// FileName returns the file name of the video without its extension.
+func (t *PathTemplate) FileName(v Video) (string, error) {
+	s, err := execute(t.file, v)
+	if err != nil {
+		return "", err
+	}
+
+	name := utils.SanitizeFileName(s, maxFileNameLen)
+	if name == "" {
+		return "", fmt.Errorf("file template rendered an empty file name for %s", v.Identifier())
+	}
+	return name, nil
+}
+
+func execute(t *template.Template, v Video) (string, error) {
+	data := PathData{
+		// titles must not introduce additional directories
+		Title:      utils.SanitizeFileName(v.Title, maxFileNameLen),
+		Season:     v.Season,
+		Episode:    v.Episode,
+		Identifier: v.Identifier(),
+		Date:       v.Date,
+		Language:   v.Language,
+	}
+
+	var sb strings.Builder
+	err := t.Execute(&sb, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render %s template for %s: %w", t.Name(), v.Identifier(), err)
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/config"
+	"github.com/jxsl13/southpark-downloader/hls"
+	"github.com/jxsl13/southpark-downloader/utils"
+)
+
+const (
+	// returns the stream of an episode identified by its mgid
+	micaUrl = "https://topaz.viacomcbs.digital/topaz/api/%s/mica.json?clientPlatform=desktop"
+)
+
+var (
+	// mgid:arc:episode:southparkstudios.com:5e9a1a8c-0a2b-11e2-8e4b-0026b9414f30
+	mgidRegex = regexp.MustCompile(`mgid:[a-z]+:[a-z]+:[a-z0-9.-]+:[0-9a-f-]+`)
+
+	ErrNoStream   = errors.New("no stream found")
+	ErrGeoBlocked = errors.New("geo-blocked")
+)
+
+// nativeDownloader downloads the HLS streams of an episode without yt-dlp
+// and muxes them with ffmpeg.
+type nativeDownloader struct {
+	Client *hls.Client
+	// url of the stream api, %s is replaced with the mgid of the episode
+	MicaUrl string
+}
+
+func (d *nativeDownloader) Download(ctx context.Context, req DownloadRequest) (_ DownloadResult, err error) {
+	var (
+		start = time.Now()
+		v     = req.Video
+	)
+
+	playlistUrl, err := d.PlaylistUrl(ctx, v.Url)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	master, video, err := d.Client.Playlist(ctx, playlistUrl)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	var audio *hls.MediaPlaylist
+	if master != nil {
+		variant, rendition := master.BestVariant()
+		video, err = d.Client.MediaPlaylist(ctx, variant.URI)
+		if err != nil {
+			return DownloadResult{}, err
+		}
+
+		if rendition != nil {
+			audio, err = d.Client.MediaPlaylist(ctx, rendition.URI)
+			if err != nil {
+				return DownloadResult{}, err
+			}
+		}
+	}
+
+	duration := time.Duration(video.Duration() * float64(time.Second)).Round(time.Second)
+	req.logf("playlist: %s", playlistUrl)
+	req.logf("segments: %d, duration: %s", len(video.Segments), duration)
+	slog.Info("downloading video",
+		"episode", v.Label(),
+		"segments", len(video.Segments),
+		"duration", duration,
+	)
+
+	// hidden files are neither picked up by media servers nor by findDownload
+	var (
+		tmpPrefix = filepath.Join(req.Dir, "."+req.FileName)
+		muxPath   = tmpPrefix + ".mux.mp4"
+		inputs    []string
+		progress  = newSegmentProgress(req, video, audio)
+	)
+	defer func() {
+		if err != nil {
+			_ = os.Remove(muxPath)
+		}
+
+		// the streams of failed downloads are resumed by the next attempt
+		if err != nil && !errors.Is(context.Cause(ctx), context.Canceled) {
+			return
+		}
+		for _, input := range inputs {
+			_ = os.Remove(input)
+			_ = os.Remove(checkpointPath(input))
+		}
+	}()
+
+	videoPath := tmpPrefix + ".video" + streamExt(video)
+	inputs = append(inputs, videoPath)
+	err = d.downloadStream(ctx, video, videoPath, progress)
+	if err != nil {
+		return DownloadResult{}, fmt.Errorf("failed to download video stream: %w", err)
+	}
+
+	if audio != nil {
+		audioPath := tmpPrefix + ".audio" + streamExt(audio)
+		inputs = append(inputs, audioPath)
+		err = d.downloadStream(ctx, audio, audioPath, progress)
+		if err != nil {
+			return DownloadResult{}, fmt.Errorf("failed to download audio stream: %w", err)
+		}
+	}
+
+	args := []string{
+		"-y",
+		"-loglevel", "error",
+	}
+	for _, input := range inputs {
+		args = append(args, "-i", input)
+	}
+
+	if audio != nil {
+		args = append(args, "-map", "0:v", "-map", "1:a")
+	} else {
+		args = append(args, "-map", "0")
+	}
+
+	args = append(args,
+		"-c", "copy",
+		// mpeg-ts contains ADTS framed AAC which mp4 does not support
+		"-bsf:a", "aac_adtstoasc",
+		muxPath,
+	)
+
+	lines, err := utils.ExecuteQuietPathApplicationWithOutput(ctx, req.Dir, "ffmpeg", args...)
+	for _, line := range lines {
+		req.logf("%s", line)
+	}
+	if err != nil {
+		req.logf("%v", err)
+		return DownloadResult{}, fmt.Errorf("failed to mux streams: %w", err)
+	}
+
+	target := req.Path(".mp4")
+	err = os.Rename(muxPath, target)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	return newDownloadResult(config.BackendNative, target, start)
+}
+
+func (d *nativeDownloader) downloadStream(ctx context.Context, p *hls.MediaPlaylist, path string, progress *segmentProgress) (err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, f.Close())
+	}()
+
+	cp := loadCheckpoint(path, len(p.Segments))
+	// drop segments that were written after the last checkpoint
+	err = f.Truncate(cp.Bytes)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(cp.Bytes, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	if cp.Segments > 0 {
+		progress.req.logf("resuming %s after %d of %d segments", filepath.Base(path), cp.Segments, len(p.Segments))
+		slog.Info("resuming download",
+			"episode", progress.req.Video.Label(),
+			"segments", cp.Segments,
+			"total", len(p.Segments),
+		)
+	}
+
+	progress.NextStream()
+	if cp.Segments > 0 {
+		progress.Resume(cp.Segments, len(p.Segments), cp.Bytes)
+	}
+
+	offset := cp.Bytes
+	return d.Client.DownloadFrom(ctx, p, f, cp.Segments, func(done, total int, written int64) {
+		cp.Segments, cp.Bytes = done, offset+written
+		err := cp.save(path)
+		if err != nil {
+			slog.Warn("failed to save download checkpoint", "path", path, "error", err)
+		}
+		progress.Update(done, total, offset+written)
+	})
+}
+
+// checkpoint is the number of segments and bytes of a stream that were written to disk.
+type checkpoint struct {
+	Segments int   `json:"segments"`
+	Total    int   `json:"total"`
+	Bytes    int64 `json:"bytes"`
+}
+
+func checkpointPath(streamPath string) string {
+	return streamPath + ".resume"
+}
+
+// loadCheckpoint returns the checkpoint of a partially downloaded stream of total segments.
+// It returns an empty checkpoint in case there is nothing to resume.
+func loadCheckpoint(streamPath string, total int) checkpoint {
+	empty := checkpoint{Total: total}
+
+	data, err := os.ReadFile(checkpointPath(streamPath))
+	if err != nil {
+		return empty
+	}
+
+	var cp checkpoint
+	err = json.Unmarshal(data, &cp)
+	if err != nil || cp.Total != total || cp.Segments > total || cp.Bytes < 0 {
+		// the playlist changed since the checkpoint was written
+		return empty
+	}
+
+	fi, err := os.Stat(streamPath)
+	if err != nil || fi.Size() < cp.Bytes {
+		return empty
+	}
+	return cp
+}
+
+func (cp checkpoint) save(streamPath string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(checkpointPath(streamPath), data, 0644)
+}
+
+// PlaylistUrl resolves the HLS playlist of an episode page.
+// Urls of playlists are returned as is.
+func (d *nativeDownloader) PlaylistUrl(ctx context.Context, pageUrl string) (string, error) {
+	u, err := url.Parse(pageUrl)
+	if err != nil {
+		return "", err
+	}
+	if path.Ext(u.Path) == ".m3u8" {
+		return pageUrl, nil
+	}
+
+	page, err := d.Client.Get(ctx, pageUrl)
+	if err != nil {
+		return "", err
+	}
+
+	mgid := mgidRegex.Find(page)
+	if mgid == nil {
+		return "", fmt.Errorf("%w: no mgid found on %s", ErrNoStream, pageUrl)
+	}
+
+	data, err := d.Client.Get(ctx, fmt.Sprintf(d.MicaUrl, url.PathEscape(string(mgid))))
+	if err != nil {
+		return "", err
+	}
+
+	var mica struct {
+		StitchedStream struct {
+			Source string `json:"source"`
+		} `json:"stitchedstream"`
+	}
+	err = json.Unmarshal(data, &mica)
+	if err != nil {
+		return "", fmt.Errorf("invalid stream response of %s: %w", mgid, err)
+	}
+
+	if mica.StitchedStream.Source == "" {
+		// the api does not return streams outside of the site's region
+		return "", fmt.Errorf("%w: %s might be %w", ErrNoStream, mgid, ErrGeoBlocked)
+	}
+	return mica.StitchedStream.Source, nil
+}
+
+// segmentProgress estimates the progress of all streams of a download
+// from the number of downloaded segments.
+type segmentProgress struct {
+	req   DownloadRequest
+	start time.Time
+	total int
+
+	// segments and bytes of previously downloaded streams
+	doneOffset  int
+	bytesOffset int64
+	// segments and bytes of the current stream
+	done  int
+	bytes int64
+	// segments and bytes that were restored from checkpoints of previous runs,
+	// they do not count towards the speed and the estimated time left
+	restoredSegments int
+	restoredBytes    int64
+}
+
+func newSegmentProgress(req DownloadRequest, streams ...*hls.MediaPlaylist) *segmentProgress {
+	total := 0
+	for _, s := range streams {
+		if s != nil {
+			total += len(s.Segments)
+		}
+	}
+
+	return &segmentProgress{
+		req:   req,
+		start: time.Now(),
+		total: total,
+	}
+}
+
+// NextStream must be called before the download of every stream.
+func (p *segmentProgress) NextStream() {
+	p.doneOffset += p.done
+	p.bytesOffset += p.bytes
+	p.done, p.bytes = 0, 0
+}
+
+// Resume is called with the checkpoint of the current stream before its remaining segments
+// are downloaded.
+func (p *segmentProgress) Resume(done, total int, written int64) {
+	p.restoredSegments += done
+	p.restoredBytes += written
+	p.Update(done, total, written)
+}
+
+// Update is called by hls.Client.Download with the progress of the current stream.
+func (p *segmentProgress) Update(done, total int, written int64) {
+	p.done, p.bytes = done, written
+
+	var (
+		segments = p.doneOffset + done
+		bytes    = p.bytesOffset + written
+		elapsed  = time.Since(p.start)
+		// downloaded by this run
+		fetched      = segments - p.restoredSegments
+		fetchedBytes = bytes - p.restoredBytes
+	)
+
+	progress := Progress{
+		Bytes:      bytes,
+		TotalBytes: bytes * int64(p.total) / int64(segments),
+		Percent:    100 * float64(segments) / float64(p.total),
+	}
+	if fetched > 0 {
+		progress.ETA = elapsed * time.Duration(p.total-segments) / time.Duration(fetched)
+	}
+	if elapsed > 0 {
+		progress.Speed = float64(fetchedBytes) / elapsed.Seconds()
+	}
+	p.req.progress(progress)
+}
+
+// streamExt returns the file extension of the concatenated segments.
+func streamExt(p *hls.MediaPlaylist) string {
+	if p.Map != nil {
+		// fragmented MP4
+		return ".mp4"
+	}
+	return ".ts"
+}
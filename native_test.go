@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/hls"
+)
+
+const testMgid = "mgid:arc:episode:southparkstudios.com:5e9a1a8c-0a2b-11e2-8e4b-0026b9414f30"
+
+// ffmpegStub concatenates the inputs into the output, which is the last argument,
+// and records its arguments next to the script.
+const ffmpegStub = `#!/bin/sh
+printf '%s\n' "$@" > "$(dirname "$0")/ffmpeg.args"
+inputs=""
+while [ $# -gt 1 ]; do
+	if [ "$1" = "-i" ]; then
+		inputs="$inputs $2"
+		shift
+	fi
+	shift
+done
+cat $inputs > "$1"
+`
+
+// stubFFmpeg puts a fake ffmpeg in front of PATH and returns a function that
+// returns the arguments of its last invocation.
+func stubFFmpeg(t *testing.T) (args func() []string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(ffmpegStub), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return func() []string {
+		data, err := os.ReadFile(filepath.Join(dir, "ffmpeg.args"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+}
+
+// newStreamServer serves an episode page, the stream api and a master playlist with
+// a low and a high quality variant that share an alternative audio rendition.
+func newStreamServer(t *testing.T, source string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	media := func(prefix string, segments int) string {
+		var sb strings.Builder
+		sb.WriteString("#EXTM3U\n#EXT-X-TARGETDURATION:4\n")
+		for idx := 0; idx < segments; idx++ {
+			fmt.Fprintf(&sb, "#EXTINF:4.0,\n%s%d.ts\n", prefix, idx)
+		}
+		sb.WriteString("#EXT-X-ENDLIST\n")
+		return sb.String()
+	}
+
+	content := map[string]string{
+		"/episodes/s01e01": `<html><script>window.__DATA__ = {"videoId": "` + testMgid + `"}</script></html>`,
+		"/master.m3u8": `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",LANGUAGE="en",NAME="English",DEFAULT=YES,URI="audio/index.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,AUDIO="aud"
+low/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=5120000,AUDIO="aud"
+high/index.m3u8
+`,
+		"/low/index.m3u8":   media("low", 2),
+		"/high/index.m3u8":  media("high", 3),
+		"/audio/index.m3u8": media("audio", 3),
+	}
+	for _, prefix := range []string{"low", "high", "audio"} {
+		for idx := 0; idx < 3; idx++ {
+			content[fmt.Sprintf("/%s/%s%d.ts", prefix, prefix, idx)] = fmt.Sprintf("<%s%d>", prefix, idx)
+		}
+	}
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		data, found := content[r.URL.Path]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(data))
+	})
+	mux.HandleFunc("/mica/", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, testMgid) {
+			http.NotFound(w, r)
+			return
+		}
+		// an empty source is returned outside of the site's region
+		var u string
+		if source != "" {
+			u = s.URL + source
+		}
+		fmt.Fprintf(w, `{"stitchedstream": {"source": %q}}`, u)
+	})
+	return s
+}
+
+func newTestNativeDownloader(s *httptest.Server) *nativeDownloader {
+	c := hls.NewClient("test", 2)
+	c.RetryDelay = time.Millisecond
+	return &nativeDownloader{
+		Client:  c,
+		MicaUrl: s.URL + "/mica/%s.json",
+	}
+}
+
+func TestNativeDownload(t *testing.T) {
+	args := stubFFmpeg(t)
+	s := newStreamServer(t, "/master.m3u8")
+	d := newTestNativeDownloader(s)
+
+	dir := t.TempDir()
+	v := Video{Season: 1, Episode: 1, Title: "Pilot", Url: s.URL + "/episodes/s01e01"}
+
+	var last Progress
+	result, err := d.Download(context.Background(), DownloadRequest{
+		Video:    v,
+		Dir:      dir,
+		FileName: "S01E01",
+		Progress: func(p Progress) { last = p },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "S01E01.mp4"); result.Path != want {
+		t.Errorf("downloaded to %s, expected %s", result.Path, want)
+	}
+	if last.Percent != 100 {
+		t.Errorf("last progress %.1f%%, expected 100%%", last.Percent)
+	}
+
+	// the best variant and its audio rendition are muxed
+	data, err := os.ReadFile(result.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<high0><high1><high2><audio0><audio1><audio2>"; string(data) != want {
+		t.Errorf("muxed %q, expected %q", data, want)
+	}
+
+	if got := strings.Join(args(), " "); !strings.Contains(got, "-map 0:v -map 1:a -c copy") {
+		t.Errorf("ffmpeg %s, expected the video of the first and the audio of the second input", got)
+	}
+
+	// the downloaded streams are removed after muxing
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("found %d files in the download directory, expected only the video", len(entries))
+	}
+}
+
+func TestNativeDownloadWithoutStream(t *testing.T) {
+	stubFFmpeg(t)
+	s := newStreamServer(t, "")
+	d := newTestNativeDownloader(s)
+
+	v := Video{Season: 1, Episode: 1, Url: s.URL + "/episodes/s01e01"}
+	_, err := d.Download(context.Background(), DownloadRequest{Video: v, Dir: t.TempDir(), FileName: "S01E01"})
+	if !errors.Is(err, ErrNoStream) {
+		t.Errorf("Download() = %v, expected %v", err, ErrNoStream)
+	}
+}
+
+func TestNativePlaylistUrl(t *testing.T) {
+	s := newStreamServer(t, "/master.m3u8")
+	d := newTestNativeDownloader(s)
+
+	got, err := d.PlaylistUrl(context.Background(), s.URL+"/episodes/s01e01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := s.URL + "/master.m3u8"; got != want {
+		t.Errorf("PlaylistUrl() = %s, expected %s", got, want)
+	}
+
+	// playlists are downloaded as is
+	got, err = d.PlaylistUrl(context.Background(), s.URL+"/high/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := s.URL + "/high/index.m3u8"; got != want {
+		t.Errorf("PlaylistUrl() = %s, expected %s", got, want)
+	}
+
+	_, err = d.PlaylistUrl(context.Background(), s.URL+"/master.m3u8.html")
+	if err == nil {
+		t.Errorf("PlaylistUrl() of a missing page succeeded")
+	}
+}
+
+func TestSegmentProgressAfterResume(t *testing.T) {
+	var got Progress
+	req := DownloadRequest{Progress: func(p Progress) { got = p }}
+
+	playlist := &hls.MediaPlaylist{Segments: make([]hls.Segment, 10)}
+	p := newSegmentProgress(req, playlist)
+	p.start = time.Now().Add(-time.Second)
+
+	p.NextStream()
+	p.Resume(5, 10, 500)
+	if got.Bytes != 500 || got.Speed != 0 || got.ETA != 0 {
+		t.Errorf("expected restored bytes without speed and ETA, got %+v", got)
+	}
+
+	p.Update(6, 10, 600)
+	// a single segment of 100 bytes was downloaded within about a second
+	if got.Bytes != 600 || got.Speed <= 50 || got.Speed > 100 {
+		t.Errorf("expected speed of the bytes downloaded after the resume, got %+v", got)
+	}
+	if got.ETA < 4*time.Second || got.ETA > 8*time.Second {
+		t.Errorf("expected ETA of about 4s for the remaining segments, got %s", got.ETA)
+	}
+}
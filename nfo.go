@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/utils"
+)
+
+// Kodi/Jellyfin compatible metadata, see https://kodi.wiki/view/NFO_files
+
+const (
+	showTitle     = "South Park"
+	showStudio    = "Comedy Central"
+	showPremiered = "1997-08-13"
+	showPlot      = "Follows the misadventures of four irreverent grade-schoolers in the quiet, dysfunctional town of South Park, Colorado."
+)
+
+type tvShowNfo struct {
+	XMLName   xml.Name `xml:"tvshow"`
+	Title     string   `xml:"title"`
+	Plot      string   `xml:"plot"`
+	Premiered string   `xml:"premiered"`
+	Studio    string   `xml:"studio"`
+	Genres    []string `xml:"genre"`
+}
+
+type seasonNfo struct {
+	XMLName      xml.Name `xml:"season"`
+	Title        string   `xml:"title"`
+	SeasonNumber int      `xml:"seasonnumber"`
+}
+
+type episodeNfo struct {
+	XMLName   xml.Name `xml:"episodedetails"`
+	Title     string   `xml:"title"`
+	ShowTitle string   `xml:"showtitle"`
+	Season    int      `xml:"season"`
+	Episode   int      `xml:"episode"`
+	Plot      string   `xml:"plot"`
+	Aired     string   `xml:"aired"`
+	Studio    string   `xml:"studio"`
+}
+
+// WriteSidecars writes the episode .nfo file and thumbnail next to the video file as well as
+// the tvshow.nfo in the output directory and the season.nfo in dir, the directory the video
+// is placed in. Existing files are not touched.
+func (c *downloadContext) WriteSidecars(ctx context.Context, v Video, videoPath, dir string) error {
+	nfoPath, thumbPath := sidecarPaths(videoPath)
+
+	err := writeNfo(filepath.Join(c.Config.OutDir, "tvshow.nfo"), tvShowNfo{
+		Title:     showTitle,
+		Plot:      showPlot,
+		Premiered: showPremiered,
+		Studio:    showStudio,
+		Genres:    []string{"Animation", "Comedy"},
+	})
+	if err != nil {
+		return err
+	}
+
+	// only write a season.nfo in case the episode is not placed directly in the
+	// output directory, which would otherwise contain all seasons
+	outDir, err := filepath.Abs(c.Config.OutDir)
+	if err != nil {
+		return err
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if dir != outDir {
+		err = writeNfo(filepath.Join(dir, "season.nfo"), seasonNfo{
+			Title:        fmt.Sprintf("Season %d", v.Season),
+			SeasonNumber: v.Season,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeNfo(nfoPath, episodeNfo{
+		Title:     v.Title,
+		ShowTitle: showTitle,
+		Season:    v.Season,
+		Episode:   v.Episode,
+		Plot:      v.Description,
+		Aired:     v.Date.Format(time.DateOnly),
+		Studio:    showStudio,
+	})
+	if err != nil {
+		return err
+	}
+
+	return DownloadImage(ctx, c.transport, v.ImageUrl, thumbPath)
+}
+
+// sidecarPaths returns the paths of the episode .nfo file and thumbnail of a video file.
+func sidecarPaths(videoPath string) (nfoPath, thumbPath string) {
+	base := strings.TrimSuffix(videoPath, filepath.Ext(videoPath))
+	return base + ".nfo", base + "-thumb.jpg"
+}
+
+// isSidecar reports whether the file is an episode .nfo file.
+func isSidecar(name string) bool {
+	return strings.HasSuffix(name, ".nfo")
+}
+
+func writeNfo(filePath string, nfo any) error {
+	found, err := utils.ExistsFile(filePath)
+	if err != nil || found {
+		return err
+	}
+
+	data, err := xml.MarshalIndent(nfo, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", filepath.Base(filePath), err)
+	}
+
+	data = append([]byte(xml.Header), data...)
+	return utils.WriteFileAtomic(filePath, data, 0644)
+}
+
+// DownloadImage fetches the image at imageUrl through trans to filePath unless the file already exists.
+func DownloadImage(ctx context.Context, trans http.RoundTripper, imageUrl, filePath string) error {
+	found, err := utils.ExistsFile(filePath)
+	if err != nil || found {
+		return err
+	}
+
+	if imageUrl == "" {
+		return fmt.Errorf("%w: image url", ErrNotFound)
+	}
+
+	client := http.Client{
+		Transport: &ContextTransport{ctx: ctx, trans: trans},
+	}
+
+	r, err := client.Get(imageUrl)
+	if err != nil {
+		return fmt.Errorf("could not get image %s: %w", imageUrl, err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not get image %s: %s", imageUrl, r.Status)
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("could not read image %s: %w", imageUrl, err)
+	}
+
+	return utils.WriteFileAtomic(filePath, data, 0644)
+}
+
+// writeSidecars is a best effort operation that must not fail the download.
+func (c *downloadContext) writeSidecars(ctx context.Context, v Video, videoPath, dir string) {
+	if !c.Config.Nfo {
+		return
+	}
+
+	err := c.WriteSidecars(ctx, v, videoPath, dir)
+	if err != nil {
+		slog.Warn("failed to write metadata", "episode", v.Label(), "error", err)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/config"
+	"github.com/mattn/go-isatty"
+)
+
+const (
+	liveInterval  = 200 * time.Millisecond
+	plainInterval = 10 * time.Second
+	barWidth      = 30
+)
+
+// ProgressDisplay aggregates the progress of concurrent downloads.
+// The live mode redraws one line per active download and an overall bar at the
+// bottom of the terminal, the plain mode periodically logs the progress.
+type ProgressDisplay struct {
+	mode  string
+	total int
+
+	mu       sync.Mutex
+	finished int
+	failed   int
+	// labels of the active downloads in the order they were started
+	order  []string
+	active map[string]Progress
+	// number of lines of the last drawn live display
+	lines int
+	// the live display is drawn between Start and Stop
+	running bool
+
+	// terminal the live display is drawn on
+	out io.Writer
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewProgressDisplay returns a display of total downloads that is drawn on out. The auto
+// mode uses the live display in case out is a terminal.
+func NewProgressDisplay(mode string, total int, out io.Writer) *ProgressDisplay {
+	if mode == config.ProgressAuto {
+		mode = config.ProgressPlain
+		if f, ok := out.(*os.File); ok && isatty.IsTerminal(f.Fd()) {
+			mode = config.ProgressLive
+		}
+	}
+
+	return &ProgressDisplay{
+		mode:   mode,
+		total:  total,
+		active: make(map[string]Progress),
+		out:    out,
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start starts rendering.
+func (d *ProgressDisplay) Start() {
+	var interval time.Duration
+	switch d.mode {
+	case config.ProgressLive:
+		interval = liveInterval
+		d.mu.Lock()
+		d.running = true
+		d.mu.Unlock()
+	case config.ProgressPlain:
+		interval = plainInterval
+	default:
+		return
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.render()
+			}
+		}
+	}()
+}
+
+// Stop stops rendering, the live display keeps its final state on the screen.
+func (d *ProgressDisplay) Stop() {
+	if d.mode != config.ProgressLive && d.mode != config.ProgressPlain {
+		return
+	}
+
+	close(d.stop)
+	d.wg.Wait()
+
+	if d.mode == config.ProgressLive {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.clear()
+		d.draw()
+		d.running = false
+		// keep the final state on the screen
+		d.lines = 0
+	}
+}
+
+// Output returns a writer that prints to w above the live display while it is running.
+// Every write is printed as a complete line.
+func (d *ProgressDisplay) Output(w io.Writer) io.Writer {
+	if d.mode != config.ProgressLive {
+		return w
+	}
+	return &displayWriter{d: d, w: w}
+}
+
+// Update sets the progress of a download, unknown downloads become active.
+func (d *ProgressDisplay) Update(label string, p Progress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, found := d.active[label]; !found {
+		d.order = append(d.order, label)
+	}
+	d.active[label] = p
+}
+
+// Finish removes the download from the active downloads.
+func (d *ProgressDisplay) Finish(label string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, found := d.active[label]; found {
+		delete(d.active, label)
+		for idx, l := range d.order {
+			if l == label {
+				d.order = append(d.order[:idx], d.order[idx+1:]...)
+				break
+			}
+		}
+	}
+
+	switch {
+	case err == nil, errors.Is(err, ErrSkipped):
+		d.finished++
+	case errors.Is(err, context.Canceled):
+		// canceled downloads are neither finished nor failed
+	default:
+		d.finished++
+		d.failed++
+	}
+}
+
+// displayWriter prints lines above the live display.
+type displayWriter struct {
+	d *ProgressDisplay
+	w io.Writer
+}
+
+func (w *displayWriter) Write(p []byte) (int, error) {
+	d := w.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.running {
+		return w.w.Write(p)
+	}
+
+	d.clear()
+	n, err := w.w.Write(p)
+	if err == nil && !bytes.HasSuffix(p, []byte("\n")) {
+		_, err = io.WriteString(w.w, "\n")
+	}
+	d.draw()
+	return n, err
+}
+
+func (d *ProgressDisplay) render() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch d.mode {
+	case config.ProgressLive:
+		d.clear()
+		d.draw()
+	case config.ProgressPlain:
+		for _, label := range d.order {
+			p := d.active[label]
+			slog.Info("download progress",
+				"episode", label,
+				"percent", fmt.Sprintf("%.1f", p.Percent),
+				"size", formatBytes(float64(p.TotalBytes)),
+				"speed", formatBytes(p.Speed)+"/s",
+				"eta", p.ETA.Round(time.Second),
+			)
+		}
+		if len(d.order) > 0 {
+			slog.Info("overall progress",
+				"finished", d.finished,
+				"total", d.total,
+				"active", len(d.active),
+				"failed", d.failed,
+			)
+		}
+	}
+}
+
+// clear removes the previously drawn live display.
+func (d *ProgressDisplay) clear() {
+	if d.lines == 0 {
+		return
+	}
+	// move the cursor to the first line of the display and clear the rest of the screen
+	fmt.Fprintf(d.out, "\x1b[%dA\r\x1b[J", d.lines)
+	d.lines = 0
+}
+
+func (d *ProgressDisplay) draw() {
+	var sb strings.Builder
+	for _, label := range d.order {
+		sb.WriteString(formatProgress(label, d.active[label]))
+		sb.WriteString("\n")
+	}
+	sb.WriteString(d.overall())
+	sb.WriteString("\n")
+
+	_, _ = io.WriteString(d.out, sb.String())
+	d.lines = len(d.order) + 1
+}
+
+// overall renders a bar of all downloads, active downloads count partially.
+func (d *ProgressDisplay) overall() string {
+	done := float64(d.finished)
+	for _, p := range d.active {
+		done += p.Percent / 100
+	}
+
+	ratio := 0.0
+	if d.total > 0 {
+		ratio = min(done/float64(d.total), 1)
+	}
+
+	filled := int(ratio * barWidth)
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", barWidth-filled)
+
+	s := fmt.Sprintf("[%s] %5.1f%%  %d/%d episodes, %d active", bar, 100*ratio, d.finished, d.total, len(d.active))
+	if d.failed > 0 {
+		s += fmt.Sprintf(", %d failed", d.failed)
+	}
+	return s
+}
+
+func formatProgress(label string, p Progress) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %5.1f%%", label, p.Percent)
+	if p.TotalBytes > 0 {
+		fmt.Fprintf(&sb, " of %s", formatBytes(float64(p.TotalBytes)))
+	}
+	if p.Speed > 0 {
+		fmt.Fprintf(&sb, " at %s/s", formatBytes(p.Speed))
+	}
+	if eta := p.ETA.Round(time.Second); eta > 0 {
+		fmt.Fprintf(&sb, " ETA %s", eta)
+	}
+	return sb.String()
+}
+
+func formatBytes(b float64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%.0fB", b)
+	}
+
+	exp := 0
+	for b >= unit*unit && exp < 4 {
+		b /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", b/unit, "KMGTP"[exp])
+}
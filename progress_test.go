@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jxsl13/southpark-downloader/config"
+)
+
+func TestProgressDisplayOutput(t *testing.T) {
+	var (
+		screen bytes.Buffer
+		logs   bytes.Buffer
+		d      = NewProgressDisplay(config.ProgressLive, 2, &screen)
+		w      = d.Output(&logs)
+	)
+
+	d.Start()
+	d.Update("S01E01 [en]", Progress{Percent: 50})
+	_, err := w.Write([]byte("log record"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Stop()
+
+	if logs.String() != "log record\n" {
+		t.Errorf("logs = %q, expected a complete line", logs.String())
+	}
+	if !strings.Contains(screen.String(), "S01E01 [en]  50.0%") {
+		t.Errorf("screen = %q, expected the active download", screen.String())
+	}
+
+	// writes after Stop do not redraw the display
+	drawn := screen.Len()
+	_, err = w.Write([]byte("after stop\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if screen.Len() != drawn {
+		t.Errorf("the display was drawn after Stop")
+	}
+}
+
+func TestProgressDisplayAutoMode(t *testing.T) {
+	d := NewProgressDisplay(config.ProgressAuto, 1, &bytes.Buffer{})
+	if d.mode != config.ProgressPlain {
+		t.Errorf("mode = %s, expected %s for an output that is no terminal", d.mode, config.ProgressPlain)
+	}
+
+	var logs bytes.Buffer
+	if w := d.Output(&logs); w != &logs {
+		t.Errorf("expected the plain mode not to wrap the log output")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+
+	"github.com/gocolly/colly/v2"
+)
+
+type episodeKey struct {
+	Season  int
+	Episode int
+}
+
+// After reports whether k is a later episode than other.
+func (k episodeKey) After(other episodeKey) bool {
+	return k.Season > other.Season || k.Season == other.Season && k.Episode > other.Episode
+}
+
+// repair compares the episodes of every season in the catalog with the episodes listed
+// by the site, records the missing ones and re-crawls only missing and failed episode pages.
+func (c *scrapeContext) repair(l Locale, w *catalogWriter) error {
+	siteUrl, _, err := GetIndex(c.Ctx, c.transport, l.IndexUrl)
+	if err != nil {
+		return err
+	}
+
+	listed, err := c.discover(l, siteUrl, w)
+	if err != nil {
+		return err
+	}
+
+	videos, err := c.All(l.Language)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	recorded, err := c.Pages(l.Language)
+	if err != nil {
+		return err
+	}
+
+	pages := make(map[episodeKey]Page, len(recorded))
+	for _, p := range recorded {
+		pages[episodeKey{p.Season, p.Episode}] = p
+	}
+
+	for _, gap := range findGaps(l.Language, videos, listed) {
+		p, found := pages[episodeKey{gap.Season, gap.Episode}]
+		if found && (p.Url != "" || gap.Url == "") {
+			continue
+		}
+
+		w.MarkPage(gap)
+	}
+	w.Sync()
+
+	recorded, err = c.Pages(l.Language)
+	if err != nil {
+		return err
+	}
+
+	if len(recorded) == 0 {
+		slog.Info("found no gaps in the catalog", "language", l.Language)
+		return nil
+	}
+
+	var (
+		missing, failed int
+		// episodes the site does not list, their pages are linked by their neighbours
+		unlisted = make(map[episodeKey]bool)
+	)
+	for _, p := range recorded {
+		if p.Status == PageFailed {
+			failed++
+		} else {
+			missing++
+		}
+
+		if p.Url == "" {
+			unlisted[episodeKey{p.Season, p.Episode}] = true
+		}
+	}
+	slog.Info("found gaps in the catalog", "language", l.Language, "missing", missing, "failed", failed)
+
+	co, err := c.newCollector(siteUrl, l, w)
+	if err != nil {
+		return err
+	}
+	c.parseEpisodes(co, l, w)
+
+	co.OnHTML("a[href]", func(e *colly.HTMLElement) {
+		u, ok := pageUrl(e.Request.URL, e.Attr("href"))
+		if !ok {
+			return
+		}
+
+		season, episode, ok := episodeNumbers(u.String())
+		if ok && unlisted[episodeKey{season, episode}] {
+			_ = visit(co, u.String(), pageEpisode)
+		}
+	})
+
+	known := make(map[episodeKey]string, len(videos))
+	for _, v := range videos {
+		known[episodeKey{v.Season, v.Episode}] = v.Url
+	}
+
+	for _, p := range recorded {
+		if c.Stop.Err() != nil {
+			break
+		}
+
+		if p.Url != "" {
+			c.visitPage(co, l, w, p.Url)
+			continue
+		}
+
+		neighbour := known[episodeKey{p.Season, p.Episode - 1}]
+		if neighbour == "" {
+			neighbour = known[episodeKey{p.Season, p.Episode + 1}]
+		}
+		if neighbour == "" {
+			slog.Warn("found no page that links to the missing episode", "language", l.Language, "episode", p.Identifier())
+			continue
+		}
+
+		err = visit(co, neighbour, pageNeighbour)
+		if err != nil && !errors.Is(err, colly.ErrAlreadyVisited) && c.Ctx.Err() == nil {
+			slog.Warn("failed to fetch episode page", "url", neighbour, "error", err)
+		}
+	}
+	co.Wait()
+	w.Sync()
+
+	var repaired, remaining int
+	for _, p := range recorded {
+		v, err := c.Episode(l.Language, p.Season, p.Episode)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				return err
+			}
+
+			remaining++
+			slog.Warn("episode is still missing", "language", l.Language, "episode", p.Identifier(), "status", p.Status, "url", p.Url)
+			continue
+		}
+
+		repaired++
+		slog.Info("repaired episode", "episode", v.Label(), "status", p.Status, "url", v.Url)
+	}
+	w.Repaired(repaired, remaining)
+	return nil
+}
+
+// findGaps returns the episodes the site lists but the catalog does not know and the
+// episode numbers that are skipped within a season of the catalog.
+func findGaps(language string, videos []Video, listed []string) []Page {
+	var (
+		known  = make(map[episodeKey]bool, len(videos))
+		seen   = make(map[episodeKey]bool, len(listed))
+		latest = make(map[int]int)
+		gaps   []Page
+	)
+
+	for _, v := range videos {
+		known[episodeKey{v.Season, v.Episode}] = true
+		latest[v.Season] = max(latest[v.Season], v.Episode)
+	}
+
+	for _, u := range listed {
+		season, episode, ok := episodeNumbers(u)
+		if !ok {
+			continue
+		}
+
+		k := episodeKey{season, episode}
+		if known[k] || seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		gaps = append(gaps, Page{
+			Language: language,
+			Season:   season,
+			Episode:  episode,
+			Url:      u,
+			Status:   PageMissing,
+		})
+	}
+
+	for season, last := range latest {
+		for episode := 1; episode < last; episode++ {
+			k := episodeKey{season, episode}
+			if known[k] || seen[k] {
+				continue
+			}
+
+			gaps = append(gaps, Page{
+				Language: language,
+				Season:   season,
+				Episode:  episode,
+				Status:   PageMissing,
+			})
+		}
+	}
+
+	sort.Slice(gaps, func(i, j int) bool {
+		if gaps[i].Season != gaps[j].Season {
+			return gaps[i].Season < gaps[j].Season
+		}
+		return gaps[i].Episode < gaps[j].Episode
+	})
+	return gaps
+}
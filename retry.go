@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// download downloads a video and retries transient failures with an exponential backoff.
+// Every attempt is recorded in the catalog.
+func (c *downloadContext) download(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+	v := req.Video
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		result, err := c.Downloader.Download(ctx, req)
+
+		recordErr := c.RecordAttempt(v, attempt, start, err)
+		if recordErr != nil {
+			slog.Warn("failed to record download attempt", "episode", v.Label(), "error", recordErr)
+		}
+
+		switch {
+		case err == nil:
+			return result, nil
+		case errors.Is(err, context.Canceled), ctx.Err() != nil:
+			return result, err
+		case attempt > c.Config.Retries, IsPermanent(err):
+			return result, err
+		case c.Stop.Err() != nil:
+			// no new attempts after the first interrupt
+			return result, err
+		}
+
+		delay := c.retryBackoff(attempt)
+		reason := FailureReason(err)
+		req.logf("attempt %d failed, retrying in %s: %v", attempt, delay.Round(time.Second), err)
+		slog.Warn("download failed, retrying",
+			"episode", v.Label(),
+			"attempt", attempt,
+			"retry_in", delay.Round(time.Second),
+			"reason", reason,
+			"error", err,
+		)
+		c.Events.EmitVideo(EventDownloadRetrying, v, Event{
+			Attempt: attempt,
+			RetryIn: delay.Seconds(),
+			Reason:  string(reason),
+			Error:   err.Error(),
+		})
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, ctx.Err()
+		case <-c.Stop.Done():
+			timer.Stop()
+			return result, err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryBackoff doubles the initial backoff with every attempt. The random jitter of up to
+// half the delay spreads the retries of parallel downloads that failed at the same time.
+func (c *downloadContext) retryBackoff(attempt int) time.Duration {
+	d := c.Config.RetryBackoff
+	for i := 1; i < attempt && d < c.Config.RetryMaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, c.Config.RetryMaxBackoff)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/hls"
+	"github.com/jxsl13/southpark-downloader/utils"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	c := newTestDownloadContext(t, &fakeDownloader{})
+	c.Config.RetryBackoff = 10 * time.Second
+	c.Config.RetryMaxBackoff = 5 * time.Minute
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, 80 * time.Second},
+		{5, 160 * time.Second},
+		{6, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			d := c.retryBackoff(tt.attempt)
+			// up to half of the delay is random jitter
+			if d < tt.max/2 || d > tt.max {
+				t.Fatalf("retryBackoff(%d) = %s, expected between %s and %s", tt.attempt, d, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+// failingDownloader fails the first downloads with err and counts the calls.
+func failingDownloader(failures int, err error) (Downloader, *int) {
+	var (
+		fake  = &fakeDownloader{Data: []byte("video")}
+		calls = new(int)
+	)
+	return downloaderFunc(func(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+		*calls++
+		if *calls <= failures {
+			return DownloadResult{}, err
+		}
+		return fake.Download(ctx, req)
+	}), calls
+}
+
+func TestDownloadRetriesTransientErrors(t *testing.T) {
+	d, calls := failingDownloader(2, errors.New("connection reset"))
+	c := newTestDownloadContext(t, d)
+	c.Config.Retries = 2
+
+	v := testVideos(1)[0]
+	err := c.DownloadVideo(context.Background(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *calls != 3 {
+		t.Errorf("made %d attempts, expected 3", *calls)
+	}
+}
+
+func TestDownloadGivesUpAfterRetries(t *testing.T) {
+	failure := errors.New("connection reset")
+	d, calls := failingDownloader(10, failure)
+	c := newTestDownloadContext(t, d)
+	c.Config.Retries = 2
+
+	v := testVideos(1)[0]
+	err := c.DownloadVideo(context.Background(), v)
+	if !errors.Is(err, failure) {
+		t.Fatalf("DownloadVideo() = %v, expected %v", err, failure)
+	}
+	if *calls != c.Config.Retries+1 {
+		t.Errorf("made %d attempts, expected %d", *calls, c.Config.Retries+1)
+	}
+
+	state, err := c.DownloadState(v.Language, v.Season, v.Episode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != StatusFailed || state.LastError != failure.Error() {
+		t.Errorf("unexpected state %+v", state)
+	}
+}
+
+func TestDownloadDoesNotRetryPermanentErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"no stream", fmt.Errorf("%w: no mgid found", ErrNoStream)},
+		{"geo blocked", fmt.Errorf("%w: mgid might be %w", ErrNoStream, ErrGeoBlocked)},
+		{"drm", fmt.Errorf("%w: SAMPLE-AES encrypted segments", hls.ErrDRM)},
+		{"not found", &hls.StatusError{URI: "https://example.com/seg.ts", StatusCode: http.StatusNotFound, Status: "404 Not Found"}},
+		{"yt-dlp geo block", utils.ErrExec{Reason: utils.ReasonGeoBlock}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !IsPermanent(tt.err) {
+				t.Fatalf("IsPermanent(%v) = false, expected true", tt.err)
+			}
+
+			d, calls := failingDownloader(10, tt.err)
+			c := newTestDownloadContext(t, d)
+
+			_, err := c.download(context.Background(), DownloadRequest{Video: testVideos(1)[0]})
+			// exec errors are not comparable
+			if err == nil || err.Error() != tt.err.Error() {
+				t.Fatalf("download() = %v, expected %v", err, tt.err)
+			}
+			if *calls != 1 {
+				t.Errorf("made %d attempts, expected a permanent error not to be retried", *calls)
+			}
+		})
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("connection reset"), false},
+		{ErrStalled, false},
+		{&hls.StatusError{StatusCode: http.StatusForbidden}, false},
+		{&hls.StatusError{StatusCode: http.StatusServiceUnavailable}, false},
+		{&hls.StatusError{StatusCode: http.StatusNotFound}, true},
+		{ErrNoStream, true},
+		{ErrGeoBlocked, true},
+		{hls.ErrDRM, true},
+		{utils.ErrExec{Reason: utils.ReasonNotFound}, true},
+		{utils.ErrExec{Reason: utils.ReasonExtractor}, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsPermanent(tt.err); got != tt.want {
+			t.Errorf("IsPermanent(%v) = %v, expected %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadDoesNotRetryAfterStop(t *testing.T) {
+	stop, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failure := errors.New("connection reset")
+	c := newTestDownloadContext(t, nil)
+	c.Stop = stop
+
+	var calls int
+	c.Downloader = downloaderFunc(func(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+		calls++
+		cancel()
+		return DownloadResult{}, failure
+	})
+
+	_, err := c.download(context.Background(), DownloadRequest{Video: testVideos(1)[0]})
+	if !errors.Is(err, failure) {
+		t.Fatalf("download() = %v, expected %v", err, failure)
+	}
+	if calls != 1 {
+		t.Errorf("made %d attempts, expected no retry after the first interrupt", calls)
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly/v2"
+	"github.com/jxsl13/southpark-downloader/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// /folgen/940f8z/south-park-cartman-und-die-analsonde-staffel-1-ep-1
+	// /episodes/940f8z/south-park-cartman-gets-an-anal-probe-season-1-ep-1
+	episodeUrlRegex = regexp.MustCompile(`/[a-z]+/[0-9a-z]+/south-park-[0-9a-z-]+-[a-z]+-([0-9]+)-[a-z]+-([0-9]+)$`)
+)
+
+// state of a scrape request, stored in the context of the request
+const (
+	// one of the page kinds below
+	ctxPageKind = "kind"
+	// number of retries of the request
+	ctxAttempt = "attempt"
+
+	// first page of a link crawl, fetched even if it is known
+	pageStart = "start"
+	// episode page, failures are recorded in the catalog
+	pageEpisode = "episode"
+	// season listing or sitemap
+	pageListing = "listing"
+	// known episode page that links to a missing episode
+	pageNeighbour = "neighbour"
+)
+
+func NewScrapeCmd(root *rootContext) *cobra.Command {
+	scrapeContext := &scrapeContext{rootContext: root}
+
+	cmd := &cobra.Command{
+		Use:      "scrape",
+		Short:    "update the local episode catalog",
+		Args:     cobra.ExactArgs(0),
+		RunE:     scrapeContext.RunE,
+		PostRunE: scrapeContext.PostRunE,
+	}
+
+	// register flags but defer parsing and validation of the final values
+	cmd.PreRunE = scrapeContext.PreRunE(cmd)
+	return cmd
+}
+
+// politeness defaults of the scraper, the download applies them to the catalog images
+const (
+	defaultScrapeDelay     = 250 * time.Millisecond
+	defaultScrapeJitter    = 250 * time.Millisecond
+	defaultThrottleRetries = 3
+)
+
+type scrapeContext struct {
+	*rootContext
+	Config    *config.ScrapeConfig
+	Output    *config.OutputConfig
+	Languages []string
+
+	// shared by all collectors and requests to the catalog hosts
+	transport http.RoundTripper
+}
+
+func (c *scrapeContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args []string) error {
+	runParser := c.RegisterFlags(cmd)
+
+	return func(cmd *cobra.Command, args []string) error {
+		err := runParser()
+		if err != nil {
+			return err
+		}
+
+		return c.Init()
+	}
+}
+
+// RegisterFlags registers the scrape flags on cmd, which allows other
+// subcommands to scrape the catalog as well.
+func (c *scrapeContext) RegisterFlags(cmd *cobra.Command) func() error {
+	c.Config = &config.ScrapeConfig{
+		UserAgent: DefaultUserAgent,
+		Language:  "en",
+		Discovery: config.DiscoveryIndex,
+
+		Parallelism:       4,
+		DomainParallelism: 2,
+
+		ScrapeDelay:     defaultScrapeDelay,
+		ScrapeJitter:    defaultScrapeJitter,
+		ThrottleRetries: defaultThrottleRetries,
+
+		PageRetries:         3,
+		PageRetryBackoff:    2 * time.Second,
+		PageRetryMaxBackoff: time.Minute,
+	}
+	c.Output = &config.OutputConfig{
+		Output: config.OutputText,
+	}
+
+	runParser := config.RegisterFlags(c.Config, false, cmd)
+	runOutputParser := config.RegisterFlags(c.Output, false, cmd)
+
+	return func() error {
+		err := runParser()
+		if err != nil {
+			return err
+		}
+
+		err = runOutputParser()
+		if err != nil {
+			return err
+		}
+		c.InitEvents(c.Output)
+
+		c.Languages, err = ParseLanguages(c.Config.Language)
+		if err != nil {
+			return err
+		}
+
+		// delays do not occupy the request slots of other domains
+		c.transport = NewPoliteTransport(
+			NewLimitTransport(http.DefaultTransport, c.Config.Parallelism, c.Config.DomainParallelism),
+			c.Config.ScrapeDelay,
+			c.Config.ScrapeJitter,
+			c.Config.ThrottleRetries,
+		)
+		return nil
+	}
+}
+
+func (c *scrapeContext) RunE(cmd *cobra.Command, args []string) error {
+	err := c.CollectUrls()
+	if err != nil {
+		return fmt.Errorf("failed to collect urls: %w", err)
+	}
+	return nil
+}
+
+// CollectUrls scrapes the episodes of all selected languages concurrently.
+func (c *scrapeContext) CollectUrls() (err error) {
+	summary := &ScrapeSummary{Command: "scrape"}
+	w := c.newCatalogWriter(summary)
+	defer func() {
+		err = errors.Join(err, w.Close())
+
+		slog.Info("scraped catalog",
+			"pages", summary.Pages,
+			"discovered", summary.Discovered,
+			"updated", summary.Updated,
+		)
+		if c.Config.Repair {
+			slog.Info("repaired catalog",
+				"repaired", summary.Repaired,
+				"remaining", summary.Remaining,
+			)
+		}
+		c.Events.Emit(Event{
+			Type:    EventRunSummary,
+			Summary: summary,
+		})
+	}()
+
+	locales := make([]Locale, 0, len(c.Languages))
+	for _, language := range c.Languages {
+		l, err := LookupLocale(language)
+		if err != nil {
+			return err
+		}
+		locales = append(locales, l)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(locales))
+	)
+	for i, l := range locales {
+		i, l := i, l
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := c.collectUrls(l, w)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", l.Language, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (c *scrapeContext) collectUrls(l Locale, w *catalogWriter) error {
+	switch {
+	case c.Config.Repair:
+		return c.repair(l, w)
+	case c.Config.Discovery == config.DiscoveryLinks:
+		return c.crawlLinks(l, w)
+	default:
+		return c.crawlIndex(l, w)
+	}
+}
+
+// crawlLinks follows the links between episode pages, starting at the last known episode.
+// Pages that failed before are fetched again.
+func (c *scrapeContext) crawlLinks(l Locale, w *catalogWriter) error {
+	startUrl, err := c.Last(l.Language)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		startUrl, err = StartingUrl(c.Ctx, c.transport, l)
+		if err != nil {
+			return err
+		}
+	}
+
+	co, err := c.newCollector(startUrl, l, w)
+	if err != nil {
+		return err
+	}
+
+	co.OnRequest(func(r *colly.Request) {
+		// the start page is fetched again in order to find the episodes after it
+		if pageKind(r) != pageStart {
+			visited, _ := c.Visited(r.URL.String())
+			if visited {
+				slog.Debug("skipping known page", "url", r.URL.String())
+				r.Abort()
+				return
+			}
+		}
+		slog.Debug("fetching page", "url", r.URL.String())
+	})
+
+	c.parseEpisodes(co, l, w)
+
+	co.OnHTML("a[href]", func(e *colly.HTMLElement) {
+		link := e.Attr("href")
+
+		if e.Request.URL.Path == link {
+			return
+		}
+
+		if e.Request.URL.String() == link {
+			return
+		}
+
+		// broken links
+		_, err := url.Parse(link)
+		if err != nil {
+			return
+		}
+
+		if episodeUrlRegex.MatchString(link) {
+			link = e.Request.AbsoluteURL(link)
+			visited, err := c.Visited(link)
+			if err != nil {
+				slog.Error("failed to check if episode was visited", "url", link, "error", err)
+				return
+			}
+
+			if !visited {
+				_ = visit(co, link, pageEpisode)
+			}
+		}
+	})
+
+	err = visit(co, startUrl, pageStart)
+	if err != nil {
+		return err
+	}
+
+	failed, err := c.FailedUrls(l.Language)
+	if err != nil {
+		return err
+	}
+	for _, u := range failed {
+		c.visitPage(co, l, w, u)
+	}
+	co.Wait()
+	return nil
+}
+
+// newCollector returns an asynchronous collector that stays on the host of siteUrl.
+// Fetched pages are counted and episode pages that cannot be fetched are recorded.
+func (c *scrapeContext) newCollector(siteUrl string, l Locale, w *catalogWriter) (*colly.Collector, error) {
+	su, err := url.Parse(siteUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	co := NewCollector(c.Ctx, c.Stop, c.Config.UserAgent)
+	co.WithTransport(&ContextTransport{ctx: c.Ctx, trans: c.transport})
+	co.Async = true
+	co.IgnoreRobotsTxt = !c.Config.Robots
+	// links to other regional sites belong to other locales
+	co.AllowedDomains = []string{su.Hostname()}
+
+	// bounds the number of goroutines, the transport bounds the requests of all collectors
+	err = co.Limit(&colly.LimitRule{
+		DomainGlob:  "*",
+		Parallelism: c.Config.Parallelism,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	co.OnScraped(func(r *colly.Response) {
+		if len(r.Body) == 0 {
+			c.pageFailed(l, w, r, ErrEmptyPage)
+		}
+	})
+
+	co.OnResponse(func(r *colly.Response) {
+		slog.Info("fetched page", "url", r.Request.URL.String(), "status", r.StatusCode)
+		w.PageFetched(r.Request.URL.String(), r.StatusCode)
+	})
+
+	co.OnError(func(r *colly.Response, err error) {
+		c.pageFailed(l, w, r, err)
+	})
+	return co, nil
+}
+
+// parseEpisodes adds or updates the episode of every episode page the collector fetches.
+func (c *scrapeContext) parseEpisodes(co *colly.Collector, l Locale, w *catalogWriter) {
+	co.OnHTML("html", func(e *colly.HTMLElement) {
+		url := e.Request.URL.String()
+		meta := e.DOM.Find("meta[property]")
+
+		var (
+			title         string
+			seasonNumber  int
+			episodeNumber int
+			description   string
+			imageUrl      string
+			contentDate   time.Time
+		)
+
+		cnt := 0
+		meta.Each(func(i int, s *goquery.Selection) {
+			val, _ := s.Attr("property")
+			switch val {
+			case "search:episodeTitle":
+				title, _ = s.Attr("content")
+				cnt++
+			case "search:seasonNumber":
+				seasonNumber, _ = strconv.Atoi(s.AttrOr("content", "0"))
+				cnt++
+			case "search:episodeNumber":
+				episodeNumber, _ = strconv.Atoi(s.AttrOr("content", "0"))
+				cnt++
+			case "og:description":
+				description, _ = s.Attr("content")
+				cnt++
+			case "og:image":
+				imageUrl, _ = s.Attr("content")
+				cnt++
+			case "og:video:release_date":
+				const contentLayout = "2006-01-02T15:04:05.000Z"
+				contentDate, _ = time.Parse(contentLayout, s.AttrOr("content", "1970-01-01T00:00:00.000Z"))
+				cnt++
+			}
+		})
+
+		if cnt < 6 {
+			slog.Warn("failed to parse meta tags", "url", url, "found", cnt)
+			w.PageFailed(l.Language, url, fmt.Errorf("found %d of 6 meta tags", cnt))
+			return
+		}
+
+		w.Episode(Video{
+			Language:    l.Language,
+			Title:       title,
+			Season:      seasonNumber,
+			Episode:     episodeNumber,
+			Url:         url,
+			Description: description,
+			ImageUrl:    imageUrl,
+			Date:        contentDate,
+		})
+	})
+}
+
+// visit enqueues a request with its own context. Unlike the requests of colly.Request.Visit
+// it does not share the context of the page that links to it.
+// Pages that the robots.txt disallows are skipped.
+func visit(co *colly.Collector, u, kind string) error {
+	ctx := colly.NewContext()
+	ctx.Put(ctxPageKind, kind)
+
+	err := co.Request(http.MethodGet, u, nil, ctx, nil)
+	if errors.Is(err, colly.ErrRobotsTxtBlocked) {
+		slog.Info("skipping page disallowed by robots.txt", "url", u)
+		return nil
+	}
+	return err
+}
+
+func pageKind(r *colly.Request) string {
+	return r.Ctx.Get(ctxPageKind)
+}
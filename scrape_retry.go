@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+var ErrEmptyPage = errors.New("empty response")
+
+// pageFailed retries a failed request until its retry budget is exhausted. Episode pages
+// that exhausted their retries are recorded in the catalog, the next run retries them.
+func (c *scrapeContext) pageFailed(l Locale, w *catalogWriter, r *colly.Response, err error) {
+	if c.Ctx.Err() != nil {
+		return
+	}
+
+	url := r.Request.URL.String()
+	if c.retryPage(r, err) {
+		return
+	}
+
+	attempts := 1
+	if retries, ok := r.Ctx.GetAny(ctxAttempt).(int); ok {
+		attempts += retries
+		err = fmt.Errorf("%w after %d attempts", err, attempts)
+	}
+
+	if pageKind(r.Request) != pageEpisode {
+		slog.Warn("failed to fetch page", "url", url, "status", r.StatusCode, "error", err)
+		return
+	}
+
+	slog.Warn("failed to fetch episode page", "url", url, "status", r.StatusCode, "error", err)
+	w.RequestFailed(l.Language, url, err)
+}
+
+// retryPage sends a failed request again after an exponential backoff and reports whether it did.
+func (c *scrapeContext) retryPage(r *colly.Response, err error) bool {
+	if !retryablePage(r.StatusCode, err) {
+		return false
+	}
+
+	attempt, _ := r.Ctx.GetAny(ctxAttempt).(int)
+	if attempt >= c.Config.PageRetries {
+		return false
+	}
+	attempt++
+
+	d := c.pageRetryBackoff(attempt)
+	slog.Warn("failed to fetch page, retrying",
+		"url", r.Request.URL.String(),
+		"status", r.StatusCode,
+		"error", err,
+		"attempt", attempt,
+		"retry_in", d,
+	)
+
+	// the collector waits for the retry, because it is sent from its request goroutine
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-c.Stop.Done():
+		return false
+	}
+
+	r.Ctx.Put(ctxAttempt, attempt)
+	err = r.Request.Retry()
+	if err != nil {
+		slog.Warn("failed to retry page", "url", r.Request.URL.String(), "error", err)
+		return false
+	}
+	return true
+}
+
+// retryablePage reports whether a failed page is retried. Only network errors, server errors and
+// empty responses are retried. 429 and 503 responses have already been retried by the
+// PoliteTransport and are not retried again.
+func retryablePage(status int, err error) bool {
+	return errors.Is(err, ErrEmptyPage) ||
+		status == 0 ||
+		(status >= http.StatusInternalServerError && !isThrottled(status))
+}
+
+// pageRetryBackoff doubles the initial backoff with every attempt, with a random jitter of up to half the delay.
+func (c *scrapeContext) pageRetryBackoff(attempt int) time.Duration {
+	d := c.Config.PageRetryBackoff
+	for i := 1; i < attempt && d < c.Config.PageRetryMaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, c.Config.PageRetryMaxBackoff)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
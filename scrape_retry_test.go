@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestRetryablePage(t *testing.T) {
+	tests := []struct {
+		status int
+		err    error
+		want   bool
+	}{
+		{0, errors.New("connection reset"), true},
+		{http.StatusOK, fmt.Errorf("%w: /folgen/abc", ErrEmptyPage), true},
+		{http.StatusInternalServerError, errors.New("Internal Server Error"), true},
+		{http.StatusBadGateway, errors.New("Bad Gateway"), true},
+		{http.StatusGatewayTimeout, errors.New("Gateway Timeout"), true},
+		// retried by the PoliteTransport
+		{http.StatusTooManyRequests, errors.New("Too Many Requests"), false},
+		{http.StatusServiceUnavailable, errors.New("Service Unavailable"), false},
+		{http.StatusNotFound, errors.New("Not Found"), false},
+		{http.StatusForbidden, errors.New("Forbidden"), false},
+	}
+
+	for _, tt := range tests {
+		if got := retryablePage(tt.status, tt.err); got != tt.want {
+			t.Errorf("retryablePage(%d, %v) = %v, expected %v", tt.status, tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// catalogWriter applies the catalog changes of concurrent scrape requests in a
+// single goroutine, which also owns the summary of the run.
+type catalogWriter struct {
+	*scrapeContext
+	summary *ScrapeSummary
+	ops     chan func()
+	done    chan struct{}
+	errs    []error
+}
+
+func (c *scrapeContext) newCatalogWriter(summary *ScrapeSummary) *catalogWriter {
+	w := &catalogWriter{
+		scrapeContext: c,
+		summary:       summary,
+		ops:           make(chan func(), 64),
+		done:          make(chan struct{}),
+	}
+
+	go func() {
+		defer close(w.done)
+		for op := range w.ops {
+			op()
+		}
+	}()
+	return w
+}
+
+// Close applies the pending changes and returns the errors of all failed pages.
+func (w *catalogWriter) Close() error {
+	close(w.ops)
+	<-w.done
+	return errors.Join(w.errs...)
+}
+
+// Sync waits until all changes that were sent before are applied.
+func (w *catalogWriter) Sync() {
+	synced := make(chan struct{})
+	w.ops <- func() { close(synced) }
+	<-synced
+}
+
+func (w *catalogWriter) PageFetched(url string, status int) {
+	w.ops <- func() {
+		w.summary.Pages++
+		w.Events.Emit(Event{
+			Type:   EventPageFetched,
+			Url:    url,
+			Status: status,
+		})
+	}
+}
+
+// Episode adds or updates an episode of the catalog.
+func (w *catalogWriter) Episode(v Video) {
+	w.ops <- func() {
+		_, err := w.scrapeContext.Episode(v.Language, v.Season, v.Episode)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			slog.Error("failed to look up episode", "url", v.Url, "error", err)
+			w.errs = append(w.errs, fmt.Errorf("%s: %w", v.Url, err))
+			return
+		}
+		known := err == nil
+
+		err = w.Insert(v)
+		if err != nil {
+			slog.Error("failed to insert episode", "url", v.Url, "error", err)
+			w.errs = append(w.errs, fmt.Errorf("%s: %w", v.Url, err))
+			return
+		}
+
+		err = w.ClearPage(v.Language, v.Season, v.Episode)
+		if err != nil {
+			slog.Error("failed to clear page state", "url", v.Url, "error", err)
+		}
+
+		if known {
+			w.summary.Updated++
+			w.Events.EmitVideo(EventEpisodeUpdated, v, Event{})
+		} else {
+			w.summary.Discovered++
+			w.Events.EmitVideo(EventEpisodeDiscovered, v, Event{})
+		}
+	}
+}
+
+// PageFailed records an episode page that could not be parsed.
+func (w *catalogWriter) PageFailed(language, url string, pageErr error) {
+	w.ops <- func() {
+		err := w.MarkPageFailed(language, url, pageErr)
+		if err != nil {
+			slog.Error("failed to record failed page", "url", url, "error", err)
+		}
+	}
+}
+
+// RequestFailed records an episode page that could not be fetched, the scrape fails
+// once all other pages are done.
+func (w *catalogWriter) RequestFailed(language, url string, requestErr error) {
+	w.PageFailed(language, url, requestErr)
+	w.ops <- func() {
+		w.errs = append(w.errs, fmt.Errorf("%s: %w", url, requestErr))
+	}
+}
+
+// MarkPage records a missing episode page.
+func (w *catalogWriter) MarkPage(p Page) {
+	w.ops <- func() {
+		err := w.scrapeContext.MarkPage(p)
+		if err != nil {
+			slog.Error("failed to record missing page", "language", p.Language, "episode", p.Identifier(), "error", err)
+			w.errs = append(w.errs, err)
+		}
+	}
+}
+
+// Repaired adds the outcome of a repair to the summary.
+func (w *catalogWriter) Repaired(repaired, remaining int) {
+	w.ops <- func() {
+		w.summary.Repaired += repaired
+		w.summary.Remaining += remaining
+	}
+}
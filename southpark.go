@@ -8,23 +8,25 @@ import (
 	"net/url"
 )
 
-func GetIndex(ctx context.Context) (url string, data []byte, err error) {
+// GetIndex fetches the index page of a locale through trans and returns the url it was redirected to.
+func GetIndex(ctx context.Context, trans http.RoundTripper, indexUrl string) (url string, data []byte, err error) {
+	url = indexUrl
 	client := http.Client{
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			url = req.URL.String()
 			return nil
 		},
-		Transport: NewContextTransport(ctx),
+		Transport: &ContextTransport{ctx: ctx, trans: trans},
 	}
 
-	r, err := client.Get("https://www.southparkstudios.com/")
+	r, err := client.Get(indexUrl)
 	if err != nil {
-		return url, data, fmt.Errorf("could not get southparkstudios.com: %w", err)
+		return url, data, fmt.Errorf("could not get %s: %w", indexUrl, err)
 	}
 	defer r.Body.Close()
 
 	if r.StatusCode != http.StatusOK {
-		return url, data, fmt.Errorf("could not get southparkstudios.com: %s", r.Status)
+		return url, data, fmt.Errorf("could not get %s: %s", indexUrl, r.Status)
 	}
 
 	data, err = io.ReadAll(r.Body)
@@ -35,38 +37,32 @@ func GetIndex(ctx context.Context) (url string, data []byte, err error) {
 	return url, data, nil
 }
 
-func InitialUrl(indexUrl string, data []byte) (u string, err error) {
+// InitialUrl returns the url of the first episode of the locale on the (possibly redirected) index host.
+func InitialUrl(indexUrl string, l Locale) (u string, err error) {
+	return SiteUrl(indexUrl, l.StartPath)
+}
+
+// SiteUrl returns the url of path on the (possibly redirected) index host.
+func SiteUrl(indexUrl, path string) (string, error) {
 	iu, err := url.ParseRequestURI(indexUrl)
 	if err != nil {
 		return "", err
 	}
 
-	/*
-		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
-		if err != nil {
-			return "", fmt.Errorf("could not parse from index %s html: %w", indexUrl, err)
-		}
-	*/
-
-	/*
-		lang, exists := doc.Find("html").First().Attr("lang")
-		if !exists {
-			return "", fmt.Errorf("could not find html lang attribute")
-		}
-
-	*/
-	iu.Path = "/episodes/940f8z/south-park-cartman-gets-an-anal-probe-season-1-ep-1"
+	iu.Path = path
+	iu.RawQuery = ""
+	iu.Fragment = ""
 
 	return iu.String(), nil
 }
 
-func StartingUrl(ctx context.Context) (string, error) {
-	url, data, err := GetIndex(ctx)
+func StartingUrl(ctx context.Context, trans http.RoundTripper, l Locale) (string, error) {
+	url, _, err := GetIndex(ctx, trans, l.IndexUrl)
 	if err != nil {
 		return "", err
 	}
 
-	url, err = InitialUrl(url, data)
+	url, err = InitialUrl(url, l)
 	if err != nil {
 		return "", err
 
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 )
 
@@ -12,23 +13,9 @@ const (
 	// create a go time layout from the above
 	ISO8601 = "2006-01-02 15:04:05.000"
 
-	createTable = `
-CREATE TABLE IF NOT EXISTS southpark (
-	season INTEGER,
-	episode INTEGER,
-	title TEXT,
-	url TEXT,
-	description TEXT,
-	imageUrl TEXT,
-	date TEXT,
-	PRIMARY KEY (season, episode)
-);
-
-CREATE INDEX IF NOT EXISTS idx_southpark_url ON southpark (url);
-`
-
 	insertVideo = `
 INSERT OR REPLACE INTO southpark (
+	language,
 	season, 
 	episode, 
 	title, 
@@ -36,11 +23,12 @@ INSERT OR REPLACE INTO southpark (
 	description, 
 	imageUrl,
 	date
-	) VALUES (?, ?, ?, ?, ?, ?, ?);
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?);
 `
 
 	lastUrl = `
 SELECT url FROM southpark
+WHERE language = ?
 ORDER BY season DESC, episode DESC
 LIMIT 1;
 `
@@ -49,33 +37,53 @@ LIMIT 1;
 SELECT url FROM southpark WHERE url = ?;
 `
 
+	// an empty language matches all languages
 	seasonVideos = `
-SELECT season, episode, title, url, description, imageUrl, date FROM southpark WHERE season = ?;
+SELECT language, season, episode, title, url, description, imageUrl, date FROM southpark
+WHERE (?1 = '' OR language = ?1) AND season = ?2
+ORDER BY season ASC, episode ASC, language ASC;
 	`
 
-	episodeVideo = `
-SELECT season, episode, title, url, description, imageUrl, date FROM southpark WHERE season = ? AND episode = ?;
+	episodeVideos = `
+SELECT language, season, episode, title, url, description, imageUrl, date FROM southpark
+WHERE (?1 = '' OR language = ?1) AND season = ?2 AND episode = ?3
+ORDER BY language ASC;
 	`
 
 	allVideos = `
-SELECT season, episode, title, url, description, imageUrl, date FROM southpark;
+SELECT language, season, episode, title, url, description, imageUrl, date FROM southpark
+WHERE (?1 = '' OR language = ?1)
+ORDER BY season ASC, episode ASC, language ASC;
 `
 )
 
 var ErrNotFound = errors.New("no entries found")
 
+// InitDB opens the catalog database and applies all pending migrations.
 func (c *rootContext) InitDB() error {
-	db, err := sql.Open("sqlite", c.Config.DBPath())
+	err := c.OpenDB()
 	if err != nil {
 		return err
 	}
 
-	c.DB = db
+	_, err = c.Migrate(false)
+	if err != nil {
+		return err
+	}
+	return nil
+}
 
-	_, err = c.DB.ExecContext(c.Ctx, createTable)
+// OpenDB opens the catalog database without touching its schema.
+func (c *rootContext) OpenDB() error {
+	// parallel download jobs write to the database concurrently and
+	// wait for each other instead of failing with SQLITE_BUSY
+	db, err := sql.Open("sqlite", c.Config.DBPath()+"?_pragma=busy_timeout(10000)")
 	if err != nil {
 		return err
 	}
+
+	slog.Debug("opened database", "path", c.Config.DBPath())
+	c.DB = db
 	return nil
 }
 
@@ -83,17 +91,18 @@ func (c *rootContext) CloseDB() error {
 	return c.DB.Close()
 }
 
-func (c *rootContext) Insert(title string, season, episode int, url, description, imageUrl string, contentDate time.Time) error {
+func (c *rootContext) Insert(v Video) error {
 
-	_, err := c.DB.ExecContext(c.Ctx, insertVideo, season, episode, title, url, description, imageUrl, contentDate.Format(ISO8601))
+	_, err := c.DB.ExecContext(c.Ctx, insertVideo, v.Language, v.Season, v.Episode, v.Title, v.Url, v.Description, v.ImageUrl, v.Date.Format(ISO8601))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (c *rootContext) Last() (string, error) {
-	rows, err := c.DB.QueryContext(c.Ctx, lastUrl)
+// Last returns the url of the latest known episode of a language.
+func (c *rootContext) Last(language string) (string, error) {
+	rows, err := c.DB.QueryContext(c.Ctx, lastUrl, language)
 	if err != nil {
 		return "", err
 	}
@@ -132,6 +141,8 @@ func (c *rootContext) Visited(url string) (bool, error) {
 }
 
 type Video struct {
+	// ISO 639-1 language code of the locale the video was scraped from
+	Language    string
 	Title       string
 	Season      int
 	Episode     int
@@ -141,69 +152,40 @@ type Video struct {
 	Date        time.Time
 }
 
-func (v *Video) Format() string {
-	return fmt.Sprintf("South_Park_S%02dE%02d.%%(ext)s", v.Season, v.Episode)
+// Identifier returns the SxxEyy notation of the video.
+func (v *Video) Identifier() string {
+	return fmt.Sprintf("S%02dE%02d", v.Season, v.Episode)
 }
 
-func (v *Video) SeasonString() string {
-	return fmt.Sprintf("S%02d", v.Season)
+// Label identifies the video in log and error messages.
+func (v *Video) Label() string {
+	return fmt.Sprintf("%s [%s]", v.Identifier(), v.Language)
 }
 
-func (c *rootContext) Season(season int) ([]Video, error) {
-	rows, err := c.DB.QueryContext(c.Ctx, seasonVideos, season)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var videos []Video
-	for rows.Next() {
-		var v Video
-		date := ""
-		err := rows.Scan(&v.Season, &v.Episode, &v.Title, &v.Url, &v.Description, &v.ImageUrl, &date)
-		if err != nil {
-			return nil, err
-		}
-
-		t, err := time.Parse(ISO8601, date)
-		if err != nil {
-			return nil, err
-		}
-		v.Date = t
-		videos = append(videos, v)
-	}
-
-	if len(videos) == 0 {
-		return nil, ErrNotFound
-	}
-
-	return videos, nil
+func (c *rootContext) Season(language string, season int) ([]Video, error) {
+	return c.queryVideos(seasonVideos, language, season)
 }
 
-func (c *rootContext) Episode(season, episode int) (video Video, err error) {
-	row := c.DB.QueryRowContext(c.Ctx, episodeVideo, season, episode)
-
-	var v Video
-	date := ""
-	err = row.Scan(&v.Season, &v.Episode, &v.Title, &v.Url, &v.Description, &v.ImageUrl, &date)
+// Episode returns the episode of a specific language.
+func (c *rootContext) Episode(language string, season, episode int) (video Video, err error) {
+	videos, err := c.queryVideos(episodeVideos, language, season, episode)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return video, ErrNotFound
-		}
 		return video, err
 	}
+	return videos[0], nil
+}
 
-	t, err := time.Parse(ISO8601, date)
-	if err != nil {
-		return video, err
-	}
-	v.Date = t
+// EpisodeVideos returns the episode in all known languages.
+func (c *rootContext) EpisodeVideos(season, episode int) ([]Video, error) {
+	return c.queryVideos(episodeVideos, "", season, episode)
+}
 
-	return v, nil
+func (c *rootContext) All(language string) ([]Video, error) {
+	return c.queryVideos(allVideos, language)
 }
 
-func (c *rootContext) All() ([]Video, error) {
-	rows, err := c.DB.QueryContext(c.Ctx, allVideos)
+func (c *rootContext) queryVideos(query string, args ...any) ([]Video, error) {
+	rows, err := c.DB.QueryContext(c.Ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -213,7 +195,7 @@ func (c *rootContext) All() ([]Video, error) {
 	for rows.Next() {
 		var v Video
 		date := ""
-		err := rows.Scan(&v.Season, &v.Episode, &v.Title, &v.Url, &v.Description, &v.ImageUrl, &date)
+		err := rows.Scan(&v.Language, &v.Season, &v.Episode, &v.Title, &v.Url, &v.Description, &v.ImageUrl, &date)
 		if err != nil {
 			return nil, err
 		}
@@ -226,6 +208,11 @@ func (c *rootContext) All() ([]Video, error) {
 		videos = append(videos, v)
 	}
 
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
 	if len(videos) == 0 {
 		return nil, ErrNotFound
 	}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/utils"
+)
+
+type DownloadStatus string
+
+const (
+	StatusPending DownloadStatus = "pending"
+	StatusRunning DownloadStatus = "running"
+	StatusDone    DownloadStatus = "done"
+	StatusFailed  DownloadStatus = "failed"
+	// only used by download attempts, canceled downloads are pending
+	StatusCanceled DownloadStatus = "canceled"
+)
+
+const (
+	downloadState = `
+SELECT language, season, episode, status, attempts, lastError, path, size, sha256, createdAt, updatedAt
+FROM downloads WHERE language = ? AND season = ? AND episode = ?;
+`
+
+	markRunning = `
+INSERT INTO downloads (language, season, episode, status, attempts, createdAt, updatedAt)
+VALUES (?, ?, ?, 'running', 1, ?, ?)
+ON CONFLICT (language, season, episode) DO UPDATE SET
+	status = 'running',
+	attempts = attempts + 1,
+	updatedAt = excluded.updatedAt;
+`
+
+	markDone = `
+UPDATE downloads SET
+	status = 'done',
+	lastError = '',
+	path = ?,
+	size = ?,
+	sha256 = ?,
+	updatedAt = ?
+WHERE language = ? AND season = ? AND episode = ?;
+`
+
+	markFailed = `
+UPDATE downloads SET
+	status = 'failed',
+	lastError = ?,
+	updatedAt = ?
+WHERE language = ? AND season = ? AND episode = ?;
+`
+
+	markPending = `
+UPDATE downloads SET
+	status = 'pending',
+	updatedAt = ?
+WHERE language = ? AND season = ? AND episode = ?;
+`
+
+	insertAttempt = `
+INSERT INTO download_attempts (language, season, episode, attempt, status, reason, error, startedAt, finishedAt)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);
+`
+
+	downloadAttempts = `
+SELECT attempt, status, reason, error, startedAt, finishedAt
+FROM download_attempts WHERE language = ? AND season = ? AND episode = ?
+ORDER BY id DESC LIMIT ?;
+`
+
+	resetRunning = `
+UPDATE downloads SET
+	status = 'pending',
+	updatedAt = ?
+WHERE status = 'running';
+`
+
+	markQueued = `
+INSERT INTO downloads (language, season, episode, status, createdAt, updatedAt)
+VALUES (?, ?, ?, 'pending', ?, ?)
+ON CONFLICT (language, season, episode) DO NOTHING;
+`
+
+	languageStatuses = `
+SELECT season, episode, status FROM downloads WHERE language = ?;
+`
+)
+
+// DownloadState is the persisted download progress of a single episode.
+type DownloadState struct {
+	Language  string
+	Season    int
+	Episode   int
+	Status    DownloadStatus
+	Attempts  int
+	LastError string
+	Path      string
+	Size      int64
+	SHA256    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// DownloadState returns the download state of an episode or ErrNotFound
+// in case the episode was never downloaded.
+func (c *rootContext) DownloadState(language string, season, episode int) (state DownloadState, err error) {
+	row := c.DB.QueryRowContext(c.Ctx, downloadState, language, season, episode)
+
+	var (
+		s                    DownloadState
+		createdAt, updatedAt string
+	)
+	err = row.Scan(
+		&s.Language,
+		&s.Season,
+		&s.Episode,
+		&s.Status,
+		&s.Attempts,
+		&s.LastError,
+		&s.Path,
+		&s.Size,
+		&s.SHA256,
+		&createdAt,
+		&updatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return state, ErrNotFound
+		}
+		return state, err
+	}
+
+	s.CreatedAt, err = time.Parse(ISO8601, createdAt)
+	if err != nil {
+		return state, err
+	}
+
+	s.UpdatedAt, err = time.Parse(ISO8601, updatedAt)
+	if err != nil {
+		return state, err
+	}
+
+	return s, nil
+}
+
+// MarkRunning creates or updates the download state of an episode and
+// increments its attempt counter.
+// Like all state changes it is not canceled by an interrupt, in order for
+// the next run to see a consistent state.
+func (c *rootContext) MarkRunning(v Video) error {
+	now := time.Now().UTC().Format(ISO8601)
+	_, err := c.DB.ExecContext(context.WithoutCancel(c.Ctx), markRunning, v.Language, v.Season, v.Episode, now, now)
+	return err
+}
+
+func (c *rootContext) MarkDone(v Video, path string, size int64, sha256 string) error {
+	now := time.Now().UTC().Format(ISO8601)
+	_, err := c.DB.ExecContext(context.WithoutCancel(c.Ctx), markDone, path, size, sha256, now, v.Language, v.Season, v.Episode)
+	return err
+}
+
+func (c *rootContext) MarkFailed(v Video, downloadErr error) error {
+	now := time.Now().UTC().Format(ISO8601)
+	_, err := c.DB.ExecContext(context.WithoutCancel(c.Ctx), markFailed, downloadErr.Error(), now, v.Language, v.Season, v.Episode)
+	return err
+}
+
+// DownloadAttempt is a single attempt to download an episode.
+type DownloadAttempt struct {
+	// starts at 1 with every run
+	Attempt    int
+	Status     DownloadStatus
+	Reason     string
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// RecordAttempt persists the outcome of a single download attempt.
+func (c *rootContext) RecordAttempt(v Video, attempt int, startedAt time.Time, downloadErr error) error {
+	var (
+		status = StatusDone
+		reason utils.Reason
+		errMsg string
+	)
+	switch {
+	case downloadErr == nil:
+	case errors.Is(downloadErr, context.Canceled):
+		status = StatusCanceled
+	default:
+		status = StatusFailed
+		reason = FailureReason(downloadErr)
+		errMsg = downloadErr.Error()
+	}
+
+	_, err := c.DB.ExecContext(context.WithoutCancel(c.Ctx), insertAttempt,
+		v.Language, v.Season, v.Episode,
+		attempt,
+		status,
+		reason,
+		errMsg,
+		startedAt.UTC().Format(ISO8601),
+		time.Now().UTC().Format(ISO8601),
+	)
+	return err
+}
+
+// DownloadAttempts returns the latest attempts to download an episode, newest first.
+func (c *rootContext) DownloadAttempts(v Video, limit int) ([]DownloadAttempt, error) {
+	rows, err := c.DB.QueryContext(c.Ctx, downloadAttempts, v.Language, v.Season, v.Episode, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []DownloadAttempt
+	for rows.Next() {
+		var (
+			a                     DownloadAttempt
+			startedAt, finishedAt string
+		)
+		err = rows.Scan(&a.Attempt, &a.Status, &a.Reason, &a.Error, &startedAt, &finishedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		a.StartedAt, err = time.Parse(ISO8601, startedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		a.FinishedAt, err = time.Parse(ISO8601, finishedAt)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// MarkError persists the error of an unfinished download. Canceled downloads are
+// reset to pending instead of being marked as failed.
+func (c *rootContext) MarkError(v Video, downloadErr error) error {
+	if errors.Is(downloadErr, context.Canceled) {
+		return c.MarkPending(v)
+	}
+	return c.MarkFailed(v, downloadErr)
+}
+
+// MarkPending resets the state of an episode whose download was canceled,
+// it is downloaded again by the next run.
+func (c *rootContext) MarkPending(v Video) error {
+	now := time.Now().UTC().Format(ISO8601)
+	_, err := c.DB.ExecContext(context.WithoutCancel(c.Ctx), markPending, now, v.Language, v.Season, v.Episode)
+	return err
+}
+
+// ResetRunning resets the state of downloads that were still running when a previous run
+// was killed and returns their number.
+func (c *rootContext) ResetRunning() (int64, error) {
+	now := time.Now().UTC().Format(ISO8601)
+	result, err := c.DB.ExecContext(c.Ctx, resetRunning, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MarkQueued records an episode as pending unless it already has a download state.
+func (c *rootContext) MarkQueued(v Video) error {
+	now := time.Now().UTC().Format(ISO8601)
+	_, err := c.DB.ExecContext(context.WithoutCancel(c.Ctx), markQueued, v.Language, v.Season, v.Episode, now, now)
+	return err
+}
+
+// DownloadStatuses returns the download status of every episode of a language that has a download state.
+func (c *rootContext) DownloadStatuses(language string) (map[episodeKey]DownloadStatus, error) {
+	rows, err := c.DB.QueryContext(c.Ctx, languageStatuses, language)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make(map[episodeKey]DownloadStatus)
+	for rows.Next() {
+		var (
+			key    episodeKey
+			status DownloadStatus
+		)
+		err = rows.Scan(&key.Season, &key.Episode, &status)
+		if err != nil {
+			return nil, err
+		}
+		statuses[key] = status
+	}
+	return statuses, rows.Err()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/utils"
+)
+
+const (
+	mergeState = `
+SELECT status, path FROM merges WHERE languages = ? AND season = ? AND episode = ?;
+`
+
+	markMergeRunning = `
+INSERT INTO merges (languages, season, episode, status, attempts, createdAt, updatedAt)
+VALUES (?, ?, ?, 'running', 1, ?, ?)
+ON CONFLICT (languages, season, episode) DO UPDATE SET
+	status = 'running',
+	attempts = attempts + 1,
+	updatedAt = excluded.updatedAt;
+`
+
+	markMergeDone = `
+UPDATE merges SET
+	status = 'done',
+	lastError = '',
+	path = ?,
+	size = ?,
+	sha256 = ?,
+	updatedAt = ?
+WHERE languages = ? AND season = ? AND episode = ?;
+`
+
+	markMergeFailed = `
+UPDATE merges SET
+	status = 'failed',
+	lastError = ?,
+	updatedAt = ?
+WHERE languages = ? AND season = ? AND episode = ?;
+`
+
+	markMergePending = `
+UPDATE merges SET
+	status = 'pending',
+	updatedAt = ?
+WHERE languages = ? AND season = ? AND episode = ?;
+`
+)
+
+// Merge identifies the merged file of an episode.
+type Merge struct {
+	// the first language provides the video stream
+	Languages []string
+	Season    int
+	Episode   int
+}
+
+// key returns the languages column of the merge, e.g. de,en.
+func (m Merge) key() string {
+	return strings.Join(m.Languages, ",")
+}
+
+// Merged returns the path of the merged file in case the episode was
+// merged successfully and the merged file still exists.
+func (c *rootContext) Merged(m Merge) (path string, merged bool, err error) {
+	var status DownloadStatus
+	err = c.DB.QueryRowContext(c.Ctx, mergeState, m.key(), m.Season, m.Episode).Scan(&status, &path)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	if status != StatusDone {
+		return "", false, nil
+	}
+
+	found, err := utils.ExistsFile(path)
+	if err != nil || !found {
+		return "", false, err
+	}
+	return path, true, nil
+}
+
+// MarkMergeRunning creates or updates the merge state of an episode and
+// increments its attempt counter.
+func (c *rootContext) MarkMergeRunning(m Merge) error {
+	now := time.Now().UTC().Format(ISO8601)
+	_, err := c.DB.ExecContext(context.WithoutCancel(c.Ctx), markMergeRunning, m.key(), m.Season, m.Episode, now, now)
+	return err
+}
+
+func (c *rootContext) MarkMergeDone(m Merge, path string, size int64, sha256 string) error {
+	now := time.Now().UTC().Format(ISO8601)
+	_, err := c.DB.ExecContext(context.WithoutCancel(c.Ctx), markMergeDone, path, size, sha256, now, m.key(), m.Season, m.Episode)
+	return err
+}
+
+// MarkMergeError persists the error of an unfinished merge. Canceled merges are
+// reset to pending instead of being marked as failed.
+func (c *rootContext) MarkMergeError(m Merge, mergeErr error) error {
+	now := time.Now().UTC().Format(ISO8601)
+	if errors.Is(mergeErr, context.Canceled) {
+		_, err := c.DB.ExecContext(context.WithoutCancel(c.Ctx), markMergePending, now, m.key(), m.Season, m.Episode)
+		return err
+	}
+
+	_, err := c.DB.ExecContext(context.WithoutCancel(c.Ctx), markMergeFailed, mergeErr.Error(), now, m.key(), m.Season, m.Episode)
+	return err
+}
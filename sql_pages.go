@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type PageStatus string
+
+const (
+	// listed by the site but not in the catalog
+	PageMissing PageStatus = "missing"
+	// fetching or parsing the episode page failed
+	PageFailed PageStatus = "failed"
+)
+
+const (
+	upsertPage = `
+INSERT INTO pages (language, season, episode, url, status, error, createdAt, updatedAt)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (language, season, episode) DO UPDATE SET
+	url = CASE WHEN excluded.url = '' THEN url ELSE excluded.url END,
+	status = excluded.status,
+	error = excluded.error,
+	updatedAt = excluded.updatedAt;
+`
+
+	deletePage = `
+DELETE FROM pages WHERE language = ? AND season = ? AND episode = ?;
+`
+
+	failedPageUrls = `
+SELECT url FROM pages
+WHERE language = ? AND status = 'failed' AND url != ''
+ORDER BY season ASC, episode ASC;
+`
+
+	// an empty language matches all languages
+	languagePages = `
+SELECT language, season, episode, url, status, error, createdAt, updatedAt FROM pages
+WHERE (?1 = '' OR language = ?1)
+ORDER BY season ASC, episode ASC, language ASC;
+`
+)
+
+// Page is an episode page that is missing from the catalog.
+type Page struct {
+	Language string
+	Season   int
+	Episode  int
+	// empty if the site does not list the episode
+	Url       string
+	Status    PageStatus
+	Error     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Identifier returns the SxxEyy notation of the page.
+func (p *Page) Identifier() string {
+	return fmt.Sprintf("S%02dE%02d", p.Season, p.Episode)
+}
+
+// episodeNumbers returns the season and episode number of an episode page url.
+func episodeNumbers(pageUrl string) (season, episode int, ok bool) {
+	u, err := url.Parse(pageUrl)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	m := episodeUrlRegex.FindStringSubmatch(u.Path)
+	if m == nil {
+		return 0, 0, false
+	}
+
+	season, _ = strconv.Atoi(m[1])
+	episode, _ = strconv.Atoi(m[2])
+	return season, episode, true
+}
+
+// MarkPage records a missing or failed episode page, the url of a previously
+// recorded page is kept if p has none.
+func (c *rootContext) MarkPage(p Page) error {
+	now := time.Now().UTC().Format(ISO8601)
+	_, err := c.DB.ExecContext(context.WithoutCancel(c.Ctx), upsertPage,
+		p.Language, p.Season, p.Episode,
+		p.Url,
+		p.Status,
+		p.Error,
+		now, now,
+	)
+	return err
+}
+
+// MarkPageFailed records an episode page that could not be fetched or parsed.
+// Urls that are no episode pages are ignored.
+func (c *rootContext) MarkPageFailed(language, pageUrl string, pageErr error) error {
+	season, episode, ok := episodeNumbers(pageUrl)
+	if !ok {
+		return nil
+	}
+
+	return c.MarkPage(Page{
+		Language: language,
+		Season:   season,
+		Episode:  episode,
+		Url:      pageUrl,
+		Status:   PageFailed,
+		Error:    pageErr.Error(),
+	})
+}
+
+// ClearPage removes the recorded state of an episode page once it is in the catalog.
+func (c *rootContext) ClearPage(language string, season, episode int) error {
+	_, err := c.DB.ExecContext(context.WithoutCancel(c.Ctx), deletePage, language, season, episode)
+	return err
+}
+
+// Pages returns the recorded missing and failed episode pages of a language.
+func (c *rootContext) Pages(language string) ([]Page, error) {
+	rows, err := c.DB.QueryContext(c.Ctx, languagePages, language)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pages []Page
+	for rows.Next() {
+		var (
+			p                    Page
+			createdAt, updatedAt string
+		)
+		err = rows.Scan(&p.Language, &p.Season, &p.Episode, &p.Url, &p.Status, &p.Error, &createdAt, &updatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		p.CreatedAt, err = time.Parse(ISO8601, createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		p.UpdatedAt, err = time.Parse(ISO8601, updatedAt)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, p)
+	}
+	return pages, rows.Err()
+}
+
+// FailedUrls returns the urls of the episode pages of a language that could not be
+// fetched or parsed by previous runs.
+func (c *rootContext) FailedUrls(language string) ([]string, error) {
+	rows, err := c.DB.QueryContext(c.Ctx, failedPageUrls, language)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []string
+	for rows.Next() {
+		var u string
+		err = rows.Scan(&u)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, rows.Err()
+}
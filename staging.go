@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/utils"
+)
+
+// staging directories that did not change for this long belong to crashed or abandoned runs
+const stagingMaxAge = 24 * time.Hour
+
+var ErrInvalidDownload = errors.New("invalid download")
+
+// InitStaging creates the staging directory and removes stale staging directories of
+// previous runs. It must be called after the shared flags have been parsed.
+func (c *downloadContext) InitStaging() error {
+	if c.Config.TempDir == "" {
+		c.Config.TempDir = c.rootContext.Config.StagingDir()
+	}
+
+	err := os.MkdirAll(c.Config.TempDir, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(c.Config.TempDir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		dir := filepath.Join(c.Config.TempDir, e.Name())
+		if !e.IsDir() || time.Since(lastModified(dir)) < stagingMaxAge {
+			continue
+		}
+
+		slog.Info("removing stale staging directory", "path", dir)
+		err = os.RemoveAll(dir)
+		if err != nil {
+			slog.Warn("failed to remove stale staging directory", "path", dir, "error", err)
+		}
+	}
+	return nil
+}
+
+// StagingDir returns the directory an episode is downloaded into before it is moved into
+// the output directory. The directory does not change between runs, which allows to resume
+// failed downloads.
+func (c *downloadContext) StagingDir(v Video) string {
+	return filepath.Join(c.Config.TempDir, fmt.Sprintf("%s_%s", v.Identifier(), v.Language))
+}
+
+// lastModified returns the latest modification time of a directory and its files.
+func lastModified(dir string) time.Time {
+	var latest time.Time
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+		return nil
+	})
+	return latest
+}
+
+// dirSize returns the total size of the files in a directory and its subdirectories.
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err == nil {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// moveDownload moves a staged video file and its sidecar files into dir and returns the
+// new path of the video. The video is moved last, in order for its sidecar files to be
+// in place once it appears.
+func moveDownload(videoPath, dir string) (string, error) {
+	nfoPath, thumbPath := sidecarPaths(videoPath)
+	for _, path := range []string{nfoPath, thumbPath} {
+		found, err := utils.ExistsFile(path)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			continue
+		}
+
+		err = utils.MoveFileAtomic(path, filepath.Join(dir, filepath.Base(path)))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	target := filepath.Join(dir, filepath.Base(videoPath))
+	err := utils.MoveFileAtomic(videoPath, target)
+	if err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// verifyDownload rejects empty files and files that ffprobe cannot read.
+func verifyDownload(ctx context.Context, path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if fi.Size() == 0 {
+		return fmt.Errorf("%w: %s is empty", ErrInvalidDownload, path)
+	}
+
+	if !utils.IsApplicationAvailable(ctx, "ffprobe") {
+		return nil
+	}
+
+	d, err := ProbeDuration(ctx, path)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidDownload, path, err)
+	}
+
+	if d <= 0 {
+		return fmt.Errorf("%w: %s has no duration", ErrInvalidDownload, path)
+	}
+	return nil
+}
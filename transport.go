@@ -2,7 +2,13 @@ package main
 
 import (
 	"context"
+	"io"
+	"log/slog"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 )
 
 func NewContextTransport(ctx context.Context) *ContextTransport {
@@ -22,3 +28,204 @@ func (t *ContextTransport) RoundTrip(req *http.Request) (*http.Response, error)
 	req = req.WithContext(t.ctx)
 	return t.trans.RoundTrip(req)
 }
+
+// LimitTransport bounds the number of concurrent requests in total and per host.
+// A request holds its slots until its response body is closed.
+type LimitTransport struct {
+	trans   http.RoundTripper
+	total   chan struct{}
+	perHost int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+func NewLimitTransport(trans http.RoundTripper, total, perHost int) *LimitTransport {
+	return &LimitTransport{
+		trans:   trans,
+		total:   make(chan struct{}, total),
+		perHost: perHost,
+		hosts:   make(map[string]chan struct{}),
+	}
+}
+
+func (t *LimitTransport) host(host string) chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	slots, found := t.hosts[host]
+	if !found {
+		slots = make(chan struct{}, t.perHost)
+		t.hosts[host] = slots
+	}
+	return slots
+}
+
+func (t *LimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	host := t.host(req.URL.Hostname())
+
+	select {
+	case t.total <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case host <- struct{}{}:
+	case <-ctx.Done():
+		<-t.total
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			<-host
+			<-t.total
+		})
+	}
+
+	resp, err := t.trans.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releaseBody releases the slots of a request once its body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+// upper bound of a single Retry-After pause
+const maxRetryAfter = 10 * time.Minute
+
+// PoliteTransport spaces the requests to every host by a delay plus a random jitter.
+// Hosts that answer with 429 Too Many Requests or 503 Service Unavailable are paused for as
+// long as their Retry-After header asks to and the request is retried afterwards.
+type PoliteTransport struct {
+	trans   http.RoundTripper
+	delay   time.Duration
+	jitter  time.Duration
+	retries int
+
+	mu sync.Mutex
+	// earliest start of the next request to a host
+	next map[string]time.Time
+}
+
+func NewPoliteTransport(trans http.RoundTripper, delay, jitter time.Duration, retries int) *PoliteTransport {
+	return &PoliteTransport{
+		trans:   trans,
+		delay:   delay,
+		jitter:  jitter,
+		retries: retries,
+		next:    make(map[string]time.Time),
+	}
+}
+
+func (t *PoliteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	for attempt := 0; ; attempt++ {
+		err := t.wait(req.Context(), host)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.trans.RoundTrip(req)
+		if err != nil || !isThrottled(resp.StatusCode) || attempt >= t.retries {
+			return resp, err
+		}
+
+		// requests whose body was consumed cannot be sent again
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+		}
+
+		d := retryAfter(resp.Header.Get("Retry-After"), attempt)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		slog.Warn("server asks to slow down, backing off",
+			"url", req.URL.String(),
+			"status", resp.StatusCode,
+			"retry_in", d,
+			"attempt", attempt+1,
+		)
+		t.pause(host, d)
+	}
+}
+
+// wait reserves the next start of a request to host and waits for it.
+func (t *PoliteTransport) wait(ctx context.Context, host string) error {
+	t.mu.Lock()
+	start := time.Now()
+	if next := t.next[host]; next.After(start) {
+		start = next
+	}
+
+	gap := t.delay
+	if t.jitter > 0 {
+		gap += time.Duration(rand.Int63n(int64(t.jitter) + 1))
+	}
+	t.next[host] = start.Add(gap)
+	t.mu.Unlock()
+
+	d := time.Until(start)
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pause delays all requests to host that start within d.
+func (t *PoliteTransport) pause(host string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if until.After(t.next[host]) {
+		t.next[host] = until
+	}
+}
+
+func isThrottled(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// retryAfter returns the pause a Retry-After header asks for, either in seconds or as a date.
+// Without a valid header the pause doubles with every attempt, starting at one second.
+func retryAfter(header string, attempt int) time.Duration {
+	d := time.Second << min(attempt, 10)
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		d = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		d = max(time.Until(date), 0)
+	}
+	return min(d, maxRetryAfter)
+}
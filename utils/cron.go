@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a standard five field cron expression:
+// minute hour day-of-month month day-of-week
+//
+// Fields support *, single values, ranges (1-5), steps (*/15, 1-30/5) and lists (1,15,30).
+// Day of week ranges from 0 (Sunday) to 6, 7 is accepted as Sunday as well.
+// In case both day of month and day of week are restricted, either of them has to match.
+// Like in Vixie cron, a field is restricted unless it starts with *, which means that */2
+// is not restricted while 1-31 is.
+// Schedules that never match, like 0 0 30 2 *, are rejected.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	domRestricted bool
+	dowRestricted bool
+}
+
+var ErrCronNeverMatches = errors.New("schedule never matches")
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a five field cron expression or one of the macros
+// @yearly, @annually, @monthly, @weekly, @daily, @midnight and @hourly.
+func ParseCron(expr string) (*CronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	var (
+		s   CronSchedule
+		err error
+	)
+
+	s.minute, err = parseCronField(fields[0], 0, 59)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron minute: %w", err)
+	}
+
+	s.hour, err = parseCronField(fields[1], 0, 23)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron hour: %w", err)
+	}
+
+	s.dom, err = parseCronField(fields[2], 1, 31)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron day of month: %w", err)
+	}
+
+	s.month, err = parseCronField(fields[3], 1, 12)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron month: %w", err)
+	}
+
+	s.dow, err = parseCronField(fields[4], 0, 7)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron day of week: %w", err)
+	}
+
+	// 7 is sunday as well
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.domRestricted = !strings.HasPrefix(fields[2], "*")
+	s.dowRestricted = !strings.HasPrefix(fields[4], "*")
+
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expr, ErrCronNeverMatches)
+	}
+	return &s, nil
+}
+
+func parseCronField(field string, minValue, maxValue int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ListSeparator) {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		lo, hi := minValue, maxValue
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			from, to, _ := strings.Cut(rangePart, "-")
+			var err error
+			lo, err = strconv.Atoi(from)
+			if err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+			hi, err = strconv.Atoi(to)
+			if err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			v, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			lo = v
+			hi = v
+			if hasStep {
+				// 5/15 means every 15 starting at 5
+				hi = maxValue
+			}
+		}
+
+		if lo < minValue || hi > maxValue || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, minValue, maxValue)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first point in time after t that matches the schedule.
+// A zero time is returned in case no such point exists within the next five years.
+// The wall clock of the result is always after the wall clock of t, which prevents a second
+// run within the hour that is repeated when daylight saving time ends.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	after := wallClock(t)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 || !wallClock(t).After(after) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// wallClock returns the date and time of t without its zone offset.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseCronInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"too few fields", "0 0 * *"},
+		{"too many fields", "0 0 * * * *"},
+		{"minute out of range", "60 * * * *"},
+		{"hour out of range", "0 24 * * *"},
+		{"day of month zero", "0 0 0 * *"},
+		{"month out of range", "0 0 1 13 *"},
+		{"day of week out of range", "0 0 * * 8"},
+		{"reversed range", "0 0 * * 5-1"},
+		{"zero step", "*/0 * * * *"},
+		{"invalid value", "a * * * *"},
+		{"unknown macro", "@sometimes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCron(tt.expr)
+			if err == nil {
+				t.Fatalf("ParseCron(%q) succeeded, expected an error", tt.expr)
+			}
+		})
+	}
+}
+
+func TestParseCronNeverMatches(t *testing.T) {
+	for _, expr := range []string{"0 0 30 2 *", "0 0 31 4 *", "0 0 31 2,4,6,9,11 *"} {
+		_, err := ParseCron(expr)
+		if !errors.Is(err, ErrCronNeverMatches) {
+			t.Errorf("ParseCron(%q) = %v, expected %v", expr, err, ErrCronNeverMatches)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	utc := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want string
+	}{
+		{"every minute", "* * * * *", "2024-01-01 10:00", "2024-01-01 10:01"},
+		{"hourly", "@hourly", "2024-01-01 10:30", "2024-01-01 11:00"},
+		{"daily", "@daily", "2024-01-01 10:30", "2024-01-02 00:00"},
+		{"weekly on sunday", "@weekly", "2024-01-01 10:30", "2024-01-07 00:00"},
+		{"monthly", "@monthly", "2024-01-15 00:00", "2024-02-01 00:00"},
+		{"yearly", "@yearly", "2024-01-15 00:00", "2025-01-01 00:00"},
+		{"single value", "30 4 * * *", "2024-01-01 04:30", "2024-01-02 04:30"},
+		{"range", "0 9-17 * * *", "2024-01-01 17:30", "2024-01-02 09:00"},
+		{"range within", "0 9-17 * * *", "2024-01-01 12:10", "2024-01-01 13:00"},
+		{"step", "*/15 * * * *", "2024-01-01 10:16", "2024-01-01 10:30"},
+		{"step with range", "10-40/10 * * * *", "2024-01-01 10:41", "2024-01-01 11:10"},
+		{"step with start", "5/20 * * * *", "2024-01-01 10:26", "2024-01-01 10:45"},
+		{"list", "0 6,18 * * *", "2024-01-01 07:00", "2024-01-01 18:00"},
+		{"day of week range", "0 0 * * 1-5", "2024-01-05 12:00", "2024-01-08 00:00"},
+		{"seven is sunday", "0 0 * * 7", "2024-01-01 00:00", "2024-01-07 00:00"},
+		{"end of month", "0 0 31 * *", "2024-02-01 00:00", "2024-03-31 00:00"},
+		{"leap day", "0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"end of year", "59 23 31 12 *", "2024-12-31 23:59", "2025-12-31 23:59"},
+
+		// either day of month or day of week has to match if both are restricted
+		{"dom or dow: dow first", "0 0 13 * 5", "2024-01-01 00:00", "2024-01-05 00:00"},
+		{"dom or dow: dom first", "0 0 2 * 5", "2024-01-01 00:00", "2024-01-02 00:00"},
+		// both have to match if only one of them is restricted
+		{"dom only", "0 0 13 * *", "2024-01-01 00:00", "2024-01-13 00:00"},
+		{"dow only", "0 0 * * 5", "2024-01-01 00:00", "2024-01-05 00:00"},
+		// fields starting with * are not restricted, even with a step
+		{"dom step over all days", "0 0 */1 * 5", "2024-01-01 00:00", "2024-01-05 00:00"},
+		{"dom step", "0 0 */2 * 1", "2024-01-01 00:00", "2024-01-15 00:00"},
+		{"minute step on mondays", "*/2 * * * 1", "2024-01-01 10:00", "2024-01-01 10:02"},
+		{"minute step on mondays from sunday", "*/2 * * * 1", "2024-01-07 10:00", "2024-01-08 00:00"},
+		{"dow step", "0 0 13 * */2", "2024-01-01 00:00", "2024-01-13 00:00"},
+		// full ranges do not start with * and are restricted
+		{"dom full range", "0 0 1-31 * 5", "2024-01-01 00:00", "2024-01-02 00:00"},
+		{"dow full range", "0 0 13 * 0-6", "2024-01-01 00:00", "2024-01-02 00:00"},
+		{"dow full range with seven", "0 0 13 * 1-7", "2024-01-01 00:00", "2024-01-02 00:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+
+			got := s.Next(utc(tt.from))
+			if want := utc(tt.want); !got.Equal(want) {
+				t.Errorf("Next(%s) = %s, expected %s", tt.from, got.Format(time.DateTime), want.Format(time.DateTime))
+			}
+		})
+	}
+}
+
+func TestCronNextSecondsAreTruncated(t *testing.T) {
+	s, err := ParseCron("* * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := time.Date(2024, 1, 1, 10, 0, 59, 999, time.UTC)
+	want := time.Date(2024, 1, 1, 10, 1, 0, 0, time.UTC)
+	if got := s.Next(from); !got.Equal(want) {
+		t.Errorf("Next(%s) = %s, expected %s", from, got, want)
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone database not available: %v", err)
+	}
+
+	date := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2024, month, day, hour, min, 0, 0, berlin)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		// 02:00 CET is followed by 03:00 CEST on 2024-03-31
+		{"skipped hour does not exist", "30 2 * * *", date(time.March, 31, 0, 0), date(time.April, 1, 2, 30)},
+		{"hour after skipped hour", "30 3 * * *", date(time.March, 31, 0, 0), date(time.March, 31, 3, 30)},
+		{"minutes across skipped hour", "*/30 * * * *", date(time.March, 31, 1, 45), date(time.March, 31, 3, 0)},
+		// 03:00 CEST is followed by 02:00 CET on 2024-10-27
+		{"repeated hour runs once", "30 2 * * *", date(time.October, 27, 0, 0), date(time.October, 27, 2, 30)},
+		{"daily after dst ends", "0 4 * * *", date(time.October, 27, 0, 0), date(time.October, 27, 4, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+
+			got := s.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, expected %s", tt.from, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("no second run in repeated hour", func(t *testing.T) {
+		s, err := ParseCron("30 2 * * *")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first := s.Next(date(time.October, 27, 0, 0))
+		second := s.Next(first)
+		if want := date(time.October, 28, 2, 30); !second.Equal(want) {
+			t.Errorf("Next(%s) = %s, expected %s", first, second, want)
+		}
+	})
+}
@@ -1,17 +1,28 @@
 package utils
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 )
 
+const (
+	// number of output lines that are kept of streamed commands
+	TailLines = 20
+	// time a canceled command has to exit after SIGTERM before it is killed
+	GracePeriod = 10 * time.Second
+)
+
 var (
 	ErrApplicationNotFound = errors.New("application not found")
 	//ErrApplicationFailed   = errors.New("application execution failed")
@@ -24,23 +35,53 @@ type ErrExec struct {
 	Cmd       string
 	Args      []string
 
+	// last lines of stdout and stderr in the order they were written
+	Tail []string
+	// classified cause of the failure
+	Reason Reason
+
 	// Optional Error Code which might be provided by Windows
 	SubExitCode int
 }
 
 func (e ErrExec) Error() string {
-	out := e.Output
-	if len(e.ErrOutput) > len(out) {
-		out = e.ErrOutput
+	// stdout of streamed commands mostly contains progress
+	out := e.ErrOutput
+	if out == "" {
+		out = e.Output
+	}
+
+	rc := fmt.Sprintf("rc %d", e.ExitCode)
+	if e.Reason != ReasonUnknown {
+		rc += fmt.Sprintf(" (%s)", e.Reason)
 	}
-	return fmt.Sprintf("application execution failed: %s %s: rc %d: %s",
+	return fmt.Sprintf("application execution failed: %s %s: %s: %s",
 		e.Cmd,
 		strings.Join(e.Args, " "),
-		e.ExitCode,
+		rc,
 		out,
 	)
 }
 
+func newErrExec(c *exec.Cmd, cmd string, args []string, stdout, stderr, tail []string) ErrExec {
+	reason := ClassifyOutput(tail)
+	if reason == ReasonUnknown && (cmd == "ffmpeg" || cmd == "ffprobe") {
+		reason = ReasonFFmpeg
+	}
+
+	errOutput := strings.Join(stderr, "\n")
+	return ErrExec{
+		ExitCode:    c.ProcessState.ExitCode(),
+		Output:      strings.TrimSpace(strings.Join(stdout, "\n")),
+		ErrOutput:   strings.TrimSpace(errOutput),
+		Cmd:         cmd,
+		Args:        args,
+		Tail:        tail,
+		Reason:      reason,
+		SubExitCode: parseSubErrorCode(errOutput),
+	}
+}
+
 // ExecuteQuietPathApplicationWithOutput executes a linux/windows command
 func ExecuteQuietPathApplicationWithOutput(ctx context.Context, workingDir, cmd string, args ...string) (lines []string, err error) {
 	available := IsApplicationAvailable(ctx, cmd)
@@ -60,18 +101,24 @@ func ExecuteQuietPathApplicationWithOutput(ctx context.Context, workingDir, cmd
 
 	c.Stderr = io.MultiWriter(combinedOut, stderrBuf)
 	c.Stdout = combinedOut
-	fmt.Printf("Executing: %s\n", c.String())
+	stop := supervise(c, GracePeriod)
+	defer stop()
+
+	slog.Debug("executing", "cmd", c.String())
 	err = c.Run()
 	if err != nil {
-
-		return nil, ErrExec{
-			ExitCode:    c.ProcessState.ExitCode(),
-			Output:      strings.TrimSpace(combinedOut.String()),
-			ErrOutput:   strings.TrimSpace(stderrBuf.String()),
-			Cmd:         cmd,
-			Args:        args,
-			SubExitCode: parseSubErrorCode(stderrBuf.String()),
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
 		}
+		combined := strings.Split(strings.TrimSpace(combinedOut.String()), "\n")
+		e := newErrExec(c, cmd, args,
+			combined,
+			strings.Split(stderrBuf.String(), "\n"),
+			combined[max(0, len(combined)-TailLines):],
+		)
+		// the complete output is kept in case of non-streamed commands
+		e.Output = strings.TrimSpace(combinedOut.String())
+		return nil, e
 	}
 
 	outStr := combinedOut.String()
@@ -84,8 +131,25 @@ func ExecuteQuietPathApplicationWithOutput(ctx context.Context, workingDir, cmd
 	return lines, nil
 }
 
-// ExecutePathApplication executes a linux/windows command
+// ExecutePathApplication executes a linux/windows command and passes its output through
+// to stdout and stderr.
 func ExecutePathApplication(ctx context.Context, workingDir, cmd string, args ...string) (err error) {
+	return ExecutePathApplicationWithLines(ctx, workingDir,
+		func(line string) {
+			// resolved on every line, as os.Stdout might be replaced while running
+			fmt.Fprintln(os.Stdout, line)
+		},
+		func(line string) {
+			fmt.Fprintln(os.Stderr, line)
+		},
+		cmd, args...,
+	)
+}
+
+// ExecutePathApplicationWithLines executes a linux/windows command and passes every line
+// of its standard output and standard error to the respective handler, which may be nil.
+// In case the command fails, the returned ErrExec contains the last TailLines lines of its output.
+func ExecutePathApplicationWithLines(ctx context.Context, workingDir string, stdout, stderr func(line string), cmd string, args ...string) (err error) {
 	available := IsApplicationAvailable(ctx, cmd)
 	if !available {
 		return fmt.Errorf("%w: %s", ErrApplicationNotFound, cmd)
@@ -97,22 +161,63 @@ func ExecutePathApplication(ctx context.Context, workingDir, cmd string, args ..
 	}
 	c.Env = os.Environ()
 
-	c.Stderr = os.Stderr
-	c.Stdout = os.Stdout
-	fmt.Printf("Executing: %s\n", c.String())
-	err = c.Run()
+	stdoutPipe, err := c.StdoutPipe()
 	if err != nil {
+		return err
+	}
+
+	stderrPipe, err := c.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	stop := supervise(c, GracePeriod)
+	defer stop()
+
+	slog.Debug("executing", "cmd", c.String())
+	err = c.Start()
+	if err != nil {
+		return err
+	}
 
-		return ErrExec{
-			ExitCode: c.ProcessState.ExitCode(),
-			Cmd:      cmd,
-			Args:     args,
+	var (
+		wg         sync.WaitGroup
+		stdoutTail = NewRingBuffer(TailLines)
+		stderrTail = NewRingBuffer(TailLines)
+		tail       = NewRingBuffer(TailLines)
+	)
+	wg.Add(2)
+	go scanLines(&wg, stdoutPipe, stdoutTail, tail, stdout)
+	go scanLines(&wg, stderrPipe, stderrTail, tail, stderr)
+	// the pipes must be drained before waiting for the process
+	wg.Wait()
+
+	err = c.Wait()
+	if err != nil {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
 		}
+		return newErrExec(c, cmd, args, stdoutTail.Lines(), stderrTail.Lines(), tail.Lines())
 	}
 
 	return nil
 }
 
+func scanLines(wg *sync.WaitGroup, r io.Reader, streamTail, tail *RingBuffer, handle func(line string)) {
+	defer wg.Done()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		streamTail.Add(line)
+		tail.Add(line)
+		if handle != nil {
+			handle(line)
+		}
+	}
+	_, _ = io.Copy(io.Discard, r)
+}
+
 // StripUnsafe remove non-printable runes, e.g. control characters in
 // a string that is meant  for consumption by terminals that support
 // control characters.
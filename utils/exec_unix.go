@@ -8,6 +8,9 @@ import (
 	"os/exec"
 	"regexp"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func IsApplicationAvailable(ctx context.Context, name string) bool {
@@ -21,6 +24,43 @@ func IsApplicationAvailable(ctx context.Context, name string) bool {
 	return true
 }
 
+// supervise starts the command in its own process group in order for cancelation to
+// terminate grandchildren as well, e.g. python and ffmpeg started by the yt-dlp wrapper script.
+// The group receives SIGTERM and SIGKILL after the grace period. stop must be called
+// after the command has been waited for.
+func supervise(c *exec.Cmd, grace time.Duration) (stop func()) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	var (
+		mu    sync.Mutex
+		done  bool
+		timer *time.Timer
+	)
+	c.Cancel = func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if done {
+			return nil
+		}
+
+		// a negative pid signals the whole process group
+		pgid := -c.Process.Pid
+		timer = time.AfterFunc(grace, func() {
+			_ = syscall.Kill(pgid, syscall.SIGKILL)
+		})
+		return syscall.Kill(pgid, syscall.SIGTERM)
+	}
+
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		done = true
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
 func parseSubErrorCode(output string) int {
 	return 0
 }
@@ -2,11 +2,41 @@ package utils
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+var (
+	// reserved characters on windows, macOS and linux
+	fileNameReplacer = strings.NewReplacer(
+		"/", "-",
+		"\\", "-",
+		"|", "-",
+		":", " -",
+		"\"", "'",
+		"*", "",
+		"?", "",
+		"<", "",
+		">", "",
+	)
+
+	// reserved file names on windows, with or without extension
+	reservedFileNames = map[string]bool{
+		"CON": true, "PRN": true, "AUX": true, "NUL": true,
+		"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+		"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+		"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+		"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+	}
 )
 
 func ExistsFile(filePath string) (bool, error) {
@@ -115,3 +145,130 @@ func Copy(ctx context.Context, src, targetDir string) error {
 	}
 	return nil
 }
+
+// FileSHA256 returns the hex encoded SHA-256 checksum and the size of a file.
+func FileSHA256(filePath string) (sum string, size int64, err error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err = io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to hash %s: %w", filePath, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// SanitizeFileName turns s into a single, portable path segment.
+// Non-printable runes and characters that are reserved on common file systems are
+// removed or replaced, leading and trailing dots and spaces are trimmed and the result
+// is truncated to at most maxLen bytes without splitting runes. maxLen <= 0 disables truncation.
+func SanitizeFileName(s string, maxLen int) string {
+	s = StripUnsafe(s)
+	s = fileNameReplacer.Replace(s)
+	s = strings.Join(strings.Fields(s), " ")
+	s = strings.Trim(truncate(s, maxLen), ". ")
+
+	base, _, _ := strings.Cut(s, ".")
+	if reservedFileNames[strings.ToUpper(base)] {
+		// the prefix must not exceed maxLen either
+		s = strings.Trim(truncate("_"+s, maxLen), ". ")
+	}
+	return s
+}
+
+// truncate cuts s to at most maxLen bytes at a rune boundary.
+func truncate(s string, maxLen int) string {
+	if maxLen <= 0 || len(s) <= maxLen {
+		return s
+	}
+
+	n := maxLen
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// WriteFileAtomic writes data to a temporary file in the same directory and
+// renames it to filePath afterwards, so readers never see partial files.
+func WriteFileAtomic(filePath string, data []byte, perm fs.FileMode) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	_, err = f.Write(data)
+	if err != nil {
+		return err
+	}
+
+	err = f.Chmod(perm)
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filePath)
+}
+
+// MoveFileAtomic moves a file to filePath, readers never see partial files.
+// Files on other file systems are copied into a temporary file next to filePath first.
+func MoveFileAtomic(src, filePath string) (err error) {
+	err = os.Rename(src, filePath)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	f, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	_, err = io.Copy(f, in)
+	if err != nil {
+		return err
+	}
+
+	err = f.Chmod(0644)
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(f.Name(), filePath)
+	if err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
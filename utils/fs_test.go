@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		maxLen int
+		want   string
+	}{
+		{"plain", "Cartman Gets an Anal Probe", 0, "Cartman Gets an Anal Probe"},
+		{"reserved characters", `a/b\c|d:e"f*g`, 0, "a-b-c-d -e'fg"},
+		{"collapsed spaces", "  a   b  ", 0, "a b"},
+		{"trimmed dots", "..a b..", 0, "a b"},
+		{"truncated", "abcdefgh", 5, "abcde"},
+		{"truncation trims dots", "abcd.efgh", 5, "abcd"},
+		{"reserved name", "con.txt", 0, "_con.txt"},
+		{"reserved name lower case", "lpt1", 0, "_lpt1"},
+		{"reserved name within limit", "con.txt", 5, "_con"},
+		{"reserved name exactly at limit", "con", 4, "_con"},
+		{"reserved name after truncation", "console", 3, "_co"},
+		{"multibyte rune not split", "aäb", 2, "a"},
+		{"multibyte rune kept", "aäb", 3, "aä"},
+		{"multibyte runes only", "ääää", 5, "ää"},
+		{"not reserved", "console", 0, "console"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SanitizeFileName(tt.in, tt.maxLen)
+			if got != tt.want {
+				t.Errorf("SanitizeFileName(%q, %d) = %q, want %q", tt.in, tt.maxLen, got, tt.want)
+			}
+			if tt.maxLen > 0 && len(got) > tt.maxLen {
+				t.Errorf("SanitizeFileName(%q, %d) = %q exceeds %d bytes", tt.in, tt.maxLen, got, tt.maxLen)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("SanitizeFileName(%q, %d) = %q is not valid UTF-8", tt.in, tt.maxLen, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"errors"
+	"regexp"
+)
+
+// Reason is the classified cause of a failed command.
+type Reason string
+
+const (
+	ReasonUnknown   Reason = ""
+	ReasonGeoBlock  Reason = "geo-block"
+	ReasonForbidden Reason = "forbidden"
+	ReasonNotFound  Reason = "not found"
+	ReasonDRM       Reason = "drm"
+	ReasonExtractor Reason = "extractor error"
+	ReasonFFmpeg    Reason = "ffmpeg failure"
+)
+
+// the first matching pattern wins, more specific reasons come first
+var reasonPatterns = []struct {
+	Reason Reason
+	Regex  *regexp.Regexp
+}{
+	{ReasonDRM, regexp.MustCompile(`(?i)\bDRM\b`)},
+	{ReasonGeoBlock, regexp.MustCompile(`(?i)geo[ -]?(restrict|block)|available (in|from) your (country|location|region)`)},
+	{ReasonForbidden, regexp.MustCompile(`(?i)HTTP Error 403|403:? Forbidden`)},
+	{ReasonNotFound, regexp.MustCompile(`(?i)HTTP Error 404|404:? Not Found`)},
+	{ReasonFFmpeg, regexp.MustCompile(`(?i)ffmpeg|ffprobe|Postprocessing|Conversion failed`)},
+	{ReasonExtractor, regexp.MustCompile(`(?i)ERROR: \[[^\]]+\]|Unsupported URL|Unable to extract|ExtractorError`)},
+}
+
+// ClassifyOutput returns the reason of a failure from the output of a command.
+func ClassifyOutput(lines []string) Reason {
+	for _, p := range reasonPatterns {
+		// the last lines usually contain the actual error
+		for idx := len(lines) - 1; idx >= 0; idx-- {
+			if p.Regex.MatchString(lines[idx]) {
+				return p.Reason
+			}
+		}
+	}
+	return ReasonUnknown
+}
+
+// ReasonOf returns the reason of a failed command in the error chain.
+func ReasonOf(err error) Reason {
+	var execErr ErrExec
+	if errors.As(err, &execErr) {
+		return execErr.Reason
+	}
+	return ReasonUnknown
+}
@@ -0,0 +1,46 @@
+package utils
+
+import "sync"
+
+// RingBuffer keeps the last lines that were added to it.
+// It is safe for concurrent use.
+type RingBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	// index of the oldest line once the buffer is full
+	next int
+	full bool
+}
+
+// NewRingBuffer returns a buffer that keeps at most size lines.
+func NewRingBuffer(size int) *RingBuffer {
+	return &RingBuffer{
+		lines: make([]string, max(1, size)),
+	}
+}
+
+// Add appends a line and drops the oldest line in case the buffer is full.
+func (b *RingBuffer) Add(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Lines returns the kept lines, oldest first.
+func (b *RingBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.full {
+		return append([]string(nil), b.lines[:b.next]...)
+	}
+
+	lines := make([]string, 0, len(b.lines))
+	lines = append(lines, b.lines[b.next:]...)
+	return append(lines, b.lines[:b.next]...)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/config"
+	"github.com/jxsl13/southpark-downloader/utils"
+	"github.com/spf13/cobra"
+)
+
+func NewWatchCmd(root *rootContext) *cobra.Command {
+	watchContext := &watchContext{
+		rootContext: root,
+		scrape:      &scrapeContext{rootContext: root},
+		download:    &downloadContext{rootContext: root},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "periodically scrape the catalog and download newly discovered episodes",
+		Long: `Periodically scrape the catalog and download newly discovered episodes.
+Episodes that are added to an empty catalog are considered new as well,
+run the scrape command first in order to only download future episodes.
+New episodes whose download failed or was interrupted are retried by the next
+checks, also after the watch was restarted.
+`,
+		Args:     cobra.ExactArgs(0),
+		RunE:     watchContext.RunE,
+		PostRunE: watchContext.PostRunE,
+	}
+
+	// register flags but defer parsing and validation of the final values
+	cmd.PreRunE = watchContext.PreRunE(cmd)
+	return cmd
+}
+
+type watchContext struct {
+	*rootContext
+	Config   *config.WatchConfig
+	Schedule *utils.CronSchedule
+
+	scrape   *scrapeContext
+	download *downloadContext
+
+	// baseline contains the latest catalog episode of every language at the start of the watch,
+	// all later episodes are new
+	baseline map[string]episodeKey
+}
+
+func (c *watchContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args []string) error {
+	c.Config = &config.WatchConfig{
+		Interval:   6 * time.Hour,
+		Backoff:    time.Minute,
+		MaxBackoff: time.Hour,
+	}
+
+	runParser := config.RegisterFlags(c.Config, false, cmd)
+	// both share the --language flag
+	runScrapeParser := c.scrape.RegisterFlags(cmd)
+	runDownloadParser := c.download.RegisterFlags(cmd)
+
+	return func(cmd *cobra.Command, args []string) error {
+		err := runParser()
+		if err != nil {
+			return err
+		}
+
+		if c.Config.Cron != "" {
+			c.Schedule, err = utils.ParseCron(c.Config.Cron)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = runScrapeParser()
+		if err != nil {
+			return err
+		}
+
+		err = runDownloadParser()
+		if err != nil {
+			return err
+		}
+		// scraping and downloading share the pacing of requests to the catalog hosts
+		c.download.transport = c.scrape.transport
+
+		err = c.Init()
+		if err != nil {
+			return err
+		}
+
+		return c.download.InitStaging()
+	}
+}
+
+func (c *watchContext) RunE(cmd *cobra.Command, args []string) error {
+	err := c.Start()
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+	for {
+		err := c.Poll()
+		if c.Stop.Err() != nil {
+			return nil
+		}
+
+		next := c.next(time.Now())
+		if next.IsZero() {
+			return errors.Join(err, fmt.Errorf("cron schedule %q: %w", c.Config.Cron, utils.ErrCronNeverMatches))
+		}
+
+		if err != nil {
+			failures++
+			slog.Error("check failed", "failures", failures, "error", err)
+
+			retry := time.Now().Add(c.backoff(failures))
+			if retry.Before(next) {
+				next = retry
+			}
+		} else {
+			failures = 0
+		}
+
+		slog.Info("waiting for next check", "at", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-c.Stop.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// Start resets downloads that were interrupted by a previous run and records the
+// latest catalog episodes, episodes that are discovered later are new.
+func (c *watchContext) Start() error {
+	reset, err := c.ResetRunning()
+	if err != nil {
+		return err
+	}
+	if reset > 0 {
+		slog.Info("reset interrupted downloads", "count", reset)
+	}
+
+	videos, err := c.Videos(c.download.Languages, 0, 0)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	c.baseline = make(map[string]episodeKey)
+	for _, v := range videos {
+		key := episodeKey{v.Season, v.Episode}
+		if key.After(c.baseline[v.Language]) {
+			c.baseline[v.Language] = key
+		}
+	}
+	return nil
+}
+
+// Poll scrapes the catalog and downloads all newly discovered episodes as well as
+// new episodes that failed to download in previous polls.
+func (c *watchContext) Poll() error {
+	// episodes that were found before the scraper failed are still new
+	scrapeErr := c.scrape.CollectUrls()
+	if scrapeErr != nil {
+		scrapeErr = fmt.Errorf("failed to collect urls: %w", scrapeErr)
+	}
+
+	videos, err := c.Queue()
+	if err != nil {
+		return errors.Join(scrapeErr, err)
+	}
+
+	if len(videos) == 0 {
+		slog.Info("no new episodes")
+		return scrapeErr
+	}
+
+	start := time.Now()
+	results := c.download.DownloadVideos(videos)
+	return errors.Join(scrapeErr, c.summarize(results, time.Since(start)))
+}
+
+// Queue returns the catalog entries of the selected languages that were not downloaded yet
+// and that are either newer than the baseline of the watch or whose download failed or was
+// interrupted after the latest downloaded episode. Newly discovered episodes are recorded as
+// pending downloads, in order for a restarted watch to still download them.
+func (c *watchContext) Queue() ([]Video, error) {
+	videos, err := c.Videos(c.download.Languages, 0, 0)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var (
+		statuses = make(map[string]map[episodeKey]DownloadStatus)
+		lastDone = make(map[string]episodeKey)
+		queue    []Video
+	)
+	for _, v := range videos {
+		if _, found := statuses[v.Language]; !found {
+			s, err := c.DownloadStatuses(v.Language)
+			if err != nil {
+				return nil, err
+			}
+			statuses[v.Language] = s
+
+			for key, status := range s {
+				if status == StatusDone && key.After(lastDone[v.Language]) {
+					lastDone[v.Language] = key
+				}
+			}
+		}
+
+		key := episodeKey{v.Season, v.Episode}
+		status, found := statuses[v.Language][key]
+		switch {
+		case status == StatusDone:
+			continue
+		case key.After(c.baseline[v.Language]):
+			if !found {
+				err = c.MarkQueued(v)
+				if err != nil {
+					return nil, err
+				}
+			}
+		case found && key.After(lastDone[v.Language]):
+		default:
+			continue
+		}
+		queue = append(queue, v)
+	}
+	return queue, nil
+}
+
+func (c *watchContext) next(now time.Time) time.Time {
+	if c.Schedule != nil {
+		return c.Schedule.Next(now)
+	}
+	return now.Add(c.Config.Interval)
+}
+
+// backoff doubles the initial backoff with every consecutive failure.
+func (c *watchContext) backoff(failures int) time.Duration {
+	d := c.Config.Backoff
+	for i := 1; i < failures && d < c.Config.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, c.Config.MaxBackoff)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestWatchQueueSurvivesRestart(t *testing.T) {
+	d := newTestDownloadContext(t, nil)
+
+	insert := func(videos ...Video) {
+		t.Helper()
+		for _, v := range videos {
+			err := d.Insert(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	start := func() *watchContext {
+		t.Helper()
+		c := &watchContext{rootContext: d.rootContext, download: d}
+		err := c.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	queued := func(c *watchContext, want ...int) {
+		t.Helper()
+		videos, err := c.Queue()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var episodes []int
+		for _, v := range videos {
+			episodes = append(episodes, v.Episode)
+		}
+		slices.Sort(episodes)
+		if !slices.Equal(episodes, want) {
+			t.Fatalf("expected episodes %v to be queued, got %v", want, episodes)
+		}
+	}
+
+	videos := testVideos(6)
+	slices.Reverse(videos)
+
+	// episodes 1 and 2 were downloaded, 3 was skipped before the watch started
+	insert(videos[:3]...)
+	for _, v := range videos[:2] {
+		err := d.MarkRunning(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = d.MarkDone(v, "", 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := start()
+	queued(c)
+
+	insert(videos[3:5]...)
+	queued(c, 4, 5)
+
+	for _, v := range videos[3:5] {
+		state, err := d.DownloadState(v.Language, v.Season, v.Episode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.Status != StatusPending {
+			t.Fatalf("expected discovered episode %d to be pending, got %s", v.Episode, state.Status)
+		}
+	}
+
+	// episode 4 failed and the watch was killed while downloading episode 5
+	for _, v := range videos[3:5] {
+		err := d.MarkRunning(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := d.MarkFailed(videos[3], errors.New("boom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c = start()
+	insert(videos[5])
+	queued(c, 4, 5, 6)
+
+	state, err := d.DownloadState(videos[4].Language, videos[4].Season, videos[4].Episode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != StatusPending {
+		t.Fatalf("expected interrupted episode to be pending, got %s", state.Status)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// number of times a stalled download is restarted
+const stallRetries = 2
+
+var ErrStalled = errors.New("download stalled")
+
+// stallDownloader cancels and restarts downloads that stopped making progress within the
+// timeout, e.g. because yt-dlp hangs on a dead connection. A download makes progress while
+// the backend reports more downloaded bytes, the files in its directory grow or its child
+// processes print output, which keeps post-processing like muxing alive.
+type stallDownloader struct {
+	Downloader
+	Timeout time.Duration
+	Retries int
+}
+
+func (d *stallDownloader) Download(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := d.download(ctx, req)
+		if !errors.Is(err, ErrStalled) || attempt > d.Retries || ctx.Err() != nil {
+			return result, err
+		}
+
+		req.logf("%v, restarting", err)
+		slog.Warn("download stalled, restarting",
+			"episode", req.Video.Label(),
+			"timeout", d.Timeout,
+			"attempt", attempt,
+		)
+	}
+}
+
+func (d *stallDownloader) download(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	timer := time.AfterFunc(d.Timeout, func() {
+		cancel(fmt.Errorf("%w: no progress for %s", ErrStalled, d.Timeout))
+	})
+	defer timer.Stop()
+
+	var (
+		mu       sync.Mutex
+		bytes    int64
+		progress = req.Progress
+	)
+	alive := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		timer.Reset(d.Timeout)
+	}
+
+	req.Progress = func(p Progress) {
+		mu.Lock()
+		grew := p.Bytes > bytes
+		bytes = max(bytes, p.Bytes)
+		mu.Unlock()
+
+		if grew {
+			alive()
+		}
+		if progress != nil {
+			progress(p)
+		}
+	}
+	req.Log = &activityWriter{w: req.Log, alive: alive}
+
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.watchDir(watchCtx, req.Dir, alive)
+	}()
+	defer func() {
+		stopWatch()
+		wg.Wait()
+	}()
+
+	result, err := d.Downloader.Download(ctx, req)
+	if err != nil {
+		// backends return context.Canceled instead of the cause
+		if cause := context.Cause(ctx); errors.Is(cause, ErrStalled) {
+			return DownloadResult{}, cause
+		}
+	}
+	return result, err
+}
+
+// watchDir calls alive whenever the files in dir grew until ctx is done.
+func (d *stallDownloader) watchDir(ctx context.Context, dir string, alive func()) {
+	if dir == "" {
+		return
+	}
+
+	ticker := time.NewTicker(max(d.Timeout/4, time.Millisecond))
+	defer ticker.Stop()
+
+	size := dirSize(dir)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if s := dirSize(dir); s > size {
+			size = s
+			alive()
+		}
+	}
+}
+
+// activityWriter forwards the output of child processes to w, which may be nil,
+// and reports every write as progress.
+type activityWriter struct {
+	w     io.Writer
+	alive func()
+}
+
+func (w *activityWriter) Write(p []byte) (int, error) {
+	w.alive()
+	if w.w == nil {
+		return len(p), nil
+	}
+	return w.w.Write(p)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hangingDownloader blocks until the download is canceled.
+func hangingDownloader(calls *int) Downloader {
+	return downloaderFunc(func(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+		*calls++
+		<-ctx.Done()
+		// like the backends, the cause is not returned
+		return DownloadResult{}, ctx.Err()
+	})
+}
+
+func TestStallDownloaderRestartsStalledDownloads(t *testing.T) {
+	var calls int
+	d := &stallDownloader{
+		Downloader: hangingDownloader(&calls),
+		Timeout:    10 * time.Millisecond,
+		Retries:    2,
+	}
+
+	_, err := d.Download(context.Background(), DownloadRequest{Video: testVideos(1)[0]})
+	if !errors.Is(err, ErrStalled) {
+		t.Fatalf("Download() = %v, expected %v", err, ErrStalled)
+	}
+	if calls != d.Retries+1 {
+		t.Errorf("started %d downloads, expected %d", calls, d.Retries+1)
+	}
+}
+
+func TestStallDownloaderKeepsGrowingDownloads(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+
+	fake := &fakeDownloader{
+		Data: make([]byte, 100),
+		// takes longer than the timeout in total but grows in time
+		Steps: 10,
+		Delay: timeout / 4,
+	}
+	d := &stallDownloader{Downloader: fake, Timeout: timeout}
+
+	var updates int
+	_, err := d.Download(context.Background(), DownloadRequest{
+		Video:    testVideos(1)[0],
+		Dir:      t.TempDir(),
+		FileName: "video",
+		Progress: func(p Progress) { updates++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updates != fake.Steps {
+		t.Errorf("got %d progress updates, expected them to be passed through", updates)
+	}
+	if len(fake.Requests) != 1 {
+		t.Errorf("started %d downloads, expected 1", len(fake.Requests))
+	}
+}
+
+func TestStallDownloaderIgnoresProgressWithoutGrowth(t *testing.T) {
+	d := &stallDownloader{Timeout: 20 * time.Millisecond}
+	d.Downloader = downloaderFunc(func(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+		ticker := time.NewTicker(d.Timeout / 4)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return DownloadResult{}, ctx.Err()
+			case <-ticker.C:
+				req.Progress(Progress{Bytes: 42})
+			}
+		}
+	})
+
+	_, err := d.Download(context.Background(), DownloadRequest{Video: testVideos(1)[0]})
+	if !errors.Is(err, ErrStalled) {
+		t.Errorf("Download() = %v, expected %v", err, ErrStalled)
+	}
+}
+
+func TestStallDownloaderReturnsCancelation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	var calls int
+	d := &stallDownloader{
+		Downloader: hangingDownloader(&calls),
+		Timeout:    time.Hour,
+		Retries:    2,
+	}
+
+	_, err := d.Download(ctx, DownloadRequest{Video: testVideos(1)[0]})
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrStalled) {
+		t.Errorf("Download() = %v, expected %v", err, context.Canceled)
+	}
+	if calls != 1 {
+		t.Errorf("started %d downloads, expected canceled downloads not to be restarted", calls)
+	}
+}
+
+// slowDownloader runs for several timeouts without reporting any progress
+// and calls step in between.
+func slowDownloader(timeout time.Duration, step func(req DownloadRequest) error) Downloader {
+	return downloaderFunc(func(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+		ticker := time.NewTicker(timeout / 8)
+		defer ticker.Stop()
+
+		for i := 0; i < 32; i++ {
+			select {
+			case <-ctx.Done():
+				return DownloadResult{}, ctx.Err()
+			case <-ticker.C:
+			}
+
+			err := step(req)
+			if err != nil {
+				return DownloadResult{}, err
+			}
+		}
+		return DownloadResult{Path: req.Path(".mp4")}, nil
+	})
+}
+
+func TestStallDownloaderKeepsDownloadsWritingToDisk(t *testing.T) {
+	const timeout = 40 * time.Millisecond
+
+	// like a muxing ffmpeg, which writes its output without reporting progress
+	d := &stallDownloader{
+		Downloader: slowDownloader(timeout, func(req DownloadRequest) error {
+			f, err := os.OpenFile(req.Path(".mp4"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = f.Write(make([]byte, 1024))
+			return errors.Join(err, f.Close())
+		}),
+		Timeout: timeout,
+	}
+
+	_, err := d.Download(context.Background(), DownloadRequest{
+		Video:    testVideos(1)[0],
+		Dir:      t.TempDir(),
+		FileName: "video",
+	})
+	if err != nil {
+		t.Errorf("Download() = %v, expected growing files to keep the download alive", err)
+	}
+}
+
+func TestStallDownloaderKeepsDownloadsWithOutput(t *testing.T) {
+	const timeout = 40 * time.Millisecond
+
+	var log strings.Builder
+	d := &stallDownloader{
+		Downloader: slowDownloader(timeout, func(req DownloadRequest) error {
+			req.logf("[Merger] Merging formats")
+			return nil
+		}),
+		Timeout: timeout,
+	}
+
+	_, err := d.Download(context.Background(), DownloadRequest{
+		Video:    testVideos(1)[0],
+		Dir:      t.TempDir(),
+		FileName: "video",
+		Log:      &log,
+	})
+	if err != nil {
+		t.Errorf("Download() = %v, expected output to keep the download alive", err)
+	}
+	if !strings.Contains(log.String(), "[Merger]") {
+		t.Errorf("output %q was not passed through", log.String())
+	}
+}
+
+func TestStallDownloaderRestartsDownloadsWithoutGrowth(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+
+	var calls int
+	d := &stallDownloader{
+		Downloader: downloaderFunc(func(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+			calls++
+			// files that exist already do not count as growth
+			<-ctx.Done()
+			return DownloadResult{}, ctx.Err()
+		}),
+		Timeout: timeout,
+		Retries: 1,
+	}
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "video.part"), make([]byte, 1024), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.Download(context.Background(), DownloadRequest{Video: testVideos(1)[0], Dir: dir, FileName: "video"})
+	if !errors.Is(err, ErrStalled) {
+		t.Fatalf("Download() = %v, expected %v", err, ErrStalled)
+	}
+	if calls != d.Retries+1 {
+		t.Errorf("started %d downloads, expected %d", calls, d.Retries+1)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jxsl13/southpark-downloader/config"
+	"github.com/jxsl13/southpark-downloader/utils"
+)
+
+// ytDlpDownloader downloads videos with the yt-dlp shell script.
+type ytDlpDownloader struct {
+	// absolute path of the yt-dlp script
+	Cmd       string
+	MinRate   string
+	Fragments int
+}
+
+// progressPrefix marks the machine-readable progress lines of yt-dlp.
+const progressPrefix = "spdl-progress"
+
+var progressTemplate = "download:" + strings.Join([]string{
+	progressPrefix,
+	"%(progress.downloaded_bytes)s",
+	"%(progress.total_bytes)s",
+	"%(progress.total_bytes_estimate)s",
+	"%(progress.speed)s",
+	"%(progress.eta)s",
+	"%(progress.fragment_index)s",
+	"%(progress.fragment_count)s",
+}, " ")
+
+func (d *ytDlpDownloader) Download(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+	start := time.Now()
+	err := utils.ExecutePathApplicationWithLines(
+		ctx,
+		req.Dir,
+		func(line string) {
+			p, ok := parseProgress(line)
+			if ok {
+				req.progress(p)
+				return
+			}
+			req.logf("%s", line)
+		},
+		func(line string) {
+			req.logf("%s", line)
+			slog.Warn("yt-dlp: "+line, "episode", req.Video.Label())
+		},
+		d.Cmd,
+		// only print the progress, errors and warnings are still printed to stderr
+		"--quiet",
+		"--progress",
+		"--newline",
+		"--progress-template",
+		progressTemplate,
+		// resume partially downloaded files and fragments
+		"--continue",
+		"--concurrent-fragments",
+		strconv.Itoa(d.Fragments),
+		"--throttled-rate",
+		d.MinRate,
+		"--output",
+		// yt-dlp output templates use % for their own fields
+		strings.ReplaceAll(req.FileName, "%", "%%")+".%(ext)s",
+		req.Video.Url,
+	)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	path, err := findDownload(req.Dir, req.FileName)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	return newDownloadResult(config.BackendYtDlp, path, start)
+}
+
+// parseProgress parses a line that was printed with the progress template.
+// Unavailable fields are printed as NA by yt-dlp.
+func parseProgress(line string) (Progress, bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 8 || fields[0] != progressPrefix {
+		return Progress{}, false
+	}
+
+	num := func(s string) float64 {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0
+		}
+		return f
+	}
+
+	var (
+		downloaded    = num(fields[1])
+		total         = num(fields[2])
+		estimate      = num(fields[3])
+		fragmentIndex = num(fields[6])
+		fragmentCount = num(fields[7])
+	)
+
+	if total == 0 {
+		total = estimate
+	}
+
+	p := Progress{
+		Bytes:      int64(downloaded),
+		TotalBytes: int64(total),
+		Speed:      num(fields[4]),
+		ETA:        time.Duration(num(fields[5])) * time.Second,
+	}
+
+	switch {
+	case total > 0:
+		p.Percent = 100 * downloaded / total
+	case fragmentCount > 0:
+		p.Percent = 100 * fragmentIndex / fragmentCount
+	}
+	p.Percent = min(p.Percent, 100)
+	return p, true
+}
+
+// findDownload returns the path of the file that yt-dlp created for the video.
+// The file extension is only known after yt-dlp finished.
+func findDownload(outDir, fileName string) (string, error) {
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		return "", err
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case e.IsDir(), !strings.HasPrefix(name, fileName+"."):
+			continue
+		case isIncomplete(name), isSidecar(name):
+			continue
+		}
+		return filepath.Abs(filepath.Join(outDir, name))
+	}
+
+	return "", fmt.Errorf("%w: downloaded file %s", ErrNotFound, filepath.Join(outDir, fileName))
+}
+
+// isIncomplete reports whether the file is a temporary file of yt-dlp.
+func isIncomplete(name string) bool {
+	return strings.Contains(name, ".part") ||
+		strings.HasSuffix(name, ".ytdl") ||
+		strings.HasSuffix(name, ".temp")
+}